@@ -0,0 +1,78 @@
+package gsh
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAlias(t *testing.T) {
+	tests := []struct {
+		script string
+		want   string
+		err    bool
+	}{
+		{"alias e=echo\ne a  b", "a b", false},
+		{"alias y='echo \"a  b\"'\ny", "a  b", false},
+		{"alias y='echo \"a  b\"'\nalias y", "alias y='echo \"a  b\"'\n", false},
+		{"alias x='echo a  b c'\nalias", "alias x='echo a  b c'\n", false},
+		{"alias e echo 'a  b'\ne c", "a  b c", false},
+		{"alias e echo 'a  b'\nalias e", "alias e='echo '\\''a  b'\\'''\n", false},
+		{"alias e=echo hi='e hello'\nhi world", "hello world", false},
+		{"alias ls='ls -d'\nls /", "/\n", false},
+		{"alias v='echo $V'\nV=1\nv\nV=2\nv", "12", false},
+		{"alias g='echo /de*'\ng", "/dev", false},
+		{"alias e=echo\n'e' x", "", true},
+		{"alias a=b b=a\na", "", true},
+		{"alias x=\nx echo z", "z", false},
+		{"alias nope", "", true},
+		{"alias -z", "", true},
+		{"alias e=echo f=echo\nunalias e\nalias", "alias f='echo'\n", false},
+		{"alias e=echo f=echo\nunalias -a\nalias", "", false},
+		{"unalias nope", "", true},
+	}
+	for _, tt := range tests {
+		s := New()
+		var out, errOut strings.Builder
+		s.Stdout, s.Stderr = &out, &errOut
+		err := s.Exec(tt.script)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: error %v", tt.script, err)
+			continue
+		}
+		if tt.want != "" && out.String() != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.script, out.String(), tt.want)
+		}
+	}
+}
+
+func TestAliasReload(t *testing.T) {
+	s := New()
+	var out strings.Builder
+	s.Stdout = &out
+	if err := s.Exec(`alias x='echo a  b "$HOME" *'`, "alias y='ls -l'", "alias"); err != nil {
+		t.Fatal(err)
+	}
+	listing := out.String()
+	c := New()
+	out.Reset()
+	c.Stdout = &out
+	if err := c.Exec(listing, "alias"); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != listing {
+		t.Errorf("reloaded %q, want %q", out.String(), listing)
+	}
+}
+
+func TestAliasCmd(t *testing.T) {
+	s := New()
+	var out strings.Builder
+	s.Stdout = &out
+	s.Exec("alias e='echo a'")
+	if err := s.Cmd("e", "b  c", "*").Run(); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "a b  c *" {
+		t.Errorf("got %q", out.String())
+	}
+}
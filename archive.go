@@ -0,0 +1,652 @@
+package gsh
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Tar creates, extracts or lists tar archives.
+//
+//	tar -c [-z] -f out.tar [-C dir] paths...
+//	tar -x -f in.tar [-C dir] [-strip-components N]
+//	tar -t -f in.tar
+//
+// Reading detects gzip and bzip2 compression automatically.  Old
+// style bundled flags such as "-xzf" or "xzf" are accepted.  Entries
+// that would extract outside of the destination are rejected.
+func Tar(s *Session, cli []string) error {
+	name, fargs := cli[0], tarFlags(cli[1:])
+	f := flag.NewFlagSet(name, flag.ContinueOnError)
+	flagCreate := f.Bool("c", false, "create an archive")
+	flagExtract := f.Bool("x", false, "extract an archive")
+	flagList := f.Bool("t", false, "list archive contents")
+	flagFile := f.String("f", "-", "archive file, '-' for stdin or stdout")
+	flagGzip := f.Bool("z", false, "gzip compress when creating")
+	flagBzip2 := f.Bool("j", false, "bzip2 compress when creating")
+	flagDir := f.String("C", ".", "change to directory first")
+	flagStrip := f.Int("strip-components", 0, "remove leading path elements when extracting")
+	flagVerbose := f.Bool("v", false, "list files processed")
+	err := f.Parse(fargs)
+	if err != nil {
+		return err
+	}
+	dir := s.Abs(*flagDir)
+
+	var list io.Writer
+	if *flagVerbose {
+		list = s.Stderr
+	}
+
+	switch {
+	case *flagCreate && !*flagExtract && !*flagList:
+		if *flagBzip2 {
+			return fmt.Errorf("%s: bzip2 compression is not supported", name)
+		}
+		if len(f.Args()) == 0 {
+			return fmt.Errorf("%s: refusing to create an empty archive", name)
+		}
+		out, closer, err := createOutput(s, *flagFile)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		err = tarCreate(s.FS, out, *flagGzip, dir, f.Args(), list)
+		if cerr := closer(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		return nil
+	case *flagExtract && !*flagCreate && !*flagList:
+		in, err := openInput(s, *flagFile)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		defer in.Close()
+		err = tarExtract(s.FS, in, dir, *flagStrip, list)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		return nil
+	case *flagList && !*flagCreate && !*flagExtract:
+		in, err := openInput(s, *flagFile)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		defer in.Close()
+		err = tarExtract(s.FS, in, "", 0, s.Stdout)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%s: exactly one of -c, -x or -t is required", name)
+	}
+}
+
+// tarFlags expands a leading bundle of single letter flags,
+// "-xzvf" or "xzvf", into separate flags.
+func tarFlags(args []string) []string {
+	if len(args) == 0 {
+		return args
+	}
+	bundle := strings.TrimPrefix(args[0], "-")
+	if len(bundle) < 2 || strings.Trim(bundle, "cxtzjvf") != "" {
+		return args
+	}
+	out := make([]string, 0, len(args)+len(bundle))
+	for _, c := range bundle {
+		out = append(out, "-"+string(c))
+	}
+	return append(out, args[1:]...)
+}
+
+// createOutput creates a file, or uses stdout if the name is "-"
+func createOutput(s *Session, fname string) (io.Writer, func() error, error) {
+	if fname == "-" {
+		if s.Stdout == nil {
+			return nil, nil, fmt.Errorf("no output")
+		}
+		return s.Stdout, func() error { return nil }, nil
+	}
+	out, err := createFile(s.FS, s.Abs(fname))
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, out.Close, nil
+}
+
+// decompress detects gzip or bzip2 compressed input by magic number
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(3)
+	switch {
+	case len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		return gzip.NewReader(br)
+	case len(magic) == 3 && string(magic) == "BZh":
+		return bzip2.NewReader(br), nil
+	}
+	return br, nil
+}
+
+func tarCreate(fsys FS, out io.Writer, compress bool, dir string, paths []string, list io.Writer) error {
+	var zw *gzip.Writer
+	if compress {
+		zw = gzip.NewWriter(out)
+		out = zw
+	}
+	tw := tar.NewWriter(out)
+	for _, p := range paths {
+		err := walk(fsys, joinDir(dir, p), func(fpath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			link := ""
+			if info.Mode()&os.ModeSymlink != 0 {
+				if link, err = fsys.Readlink(fpath); err != nil {
+					return err
+				}
+			}
+			hdr, err := tar.FileInfoHeader(info, link)
+			if err != nil {
+				return err
+			}
+			hdr.Name = archiveName(dir, fpath)
+			if info.IsDir() {
+				hdr.Name += "/"
+			}
+			if list != nil {
+				fmt.Fprintln(list, hdr.Name)
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			in, err := openFile(fsys, fpath)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, in)
+			in.Close()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if zw != nil {
+		return zw.Close()
+	}
+	return nil
+}
+
+// tarExtract extracts into dir.  If dir is empty, the names are
+// only listed.
+func tarExtract(fsys FS, in io.Reader, dir string, strip int, list io.Writer) error {
+	r, err := decompress(in)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	var dirs []*tar.Header
+	root := realPath(fsys, dir)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if list != nil {
+			fmt.Fprintln(list, hdr.Name)
+		}
+		if dir == "" {
+			continue
+		}
+		target, ok, err := extractPath(dir, hdr.Name, strip)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		target, err = resolveTarget(fsys, root, target, hdr.Typeflag == tar.TypeDir)
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := fsys.MkdirAll(target, 0777); err != nil {
+				return err
+			}
+			// permissions are set last, in case they are read-only
+			hdr.Name = target
+			dirs = append(dirs, hdr)
+		case tar.TypeReg:
+			if err := writeFile(fsys, target, tr, mode); err != nil {
+				return err
+			}
+			fsys.Chtimes(target, hdr.ModTime, hdr.ModTime)
+		case tar.TypeSymlink:
+			if err := checkLink(fsys, root, target, hdr.Linkname); err != nil {
+				return err
+			}
+			if err := fsys.MkdirAll(filepath.Dir(target), 0777); err != nil {
+				return err
+			}
+			fsys.Remove(target)
+			if err := fsys.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			source, ok, err := extractPath(dir, hdr.Linkname, strip)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("%s: hard link to stripped path %s", hdr.Name, hdr.Linkname)
+			}
+			if source, err = resolveTarget(fsys, root, source, false); err != nil {
+				return err
+			}
+			fsys.Remove(target)
+			if err := fsys.Link(source, target); err != nil {
+				return err
+			}
+		default:
+			// devices, fifos and such are skipped
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		fsys.Chmod(dirs[i].Name, os.FileMode(dirs[i].Mode).Perm())
+		fsys.Chtimes(dirs[i].Name, dirs[i].ModTime, dirs[i].ModTime)
+	}
+	return nil
+}
+
+// joinDir resolves a relative path against dir
+func joinDir(dir string, p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(dir, p)
+}
+
+// archiveName is the name of a file as stored in an archive.  Files
+// outside of dir are stored with the leading "/" removed, like tar does.
+func archiveName(dir string, fpath string) string {
+	if rel, err := filepath.Rel(dir, fpath); err == nil && within(dir, fpath) {
+		return filepath.ToSlash(rel)
+	}
+	return strings.TrimLeft(filepath.ToSlash(fpath), "/")
+}
+
+// extractPath returns where an archive entry should be written.
+// It returns false if the entry is removed by stripping components,
+// and an error if the entry would be written outside of dir.
+func extractPath(dir string, name string, strip int) (string, bool, error) {
+	name = filepath.ToSlash(name)
+	if strip > 0 {
+		parts := strings.Split(strings.Trim(name, "/"), "/")
+		if len(parts) <= strip {
+			return "", false, nil
+		}
+		name = strings.Join(parts[strip:], "/")
+	}
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") {
+		return "", false, fmt.Errorf("%s: absolute path in archive", name)
+	}
+	target := filepath.Join(dir, filepath.FromSlash(name))
+	if !within(dir, target) {
+		return "", false, fmt.Errorf("%s: path escapes destination", name)
+	}
+	return target, true, nil
+}
+
+// resolveTarget returns target with the symlinks in its directory
+// resolved, and the target itself if follow is set.  It is an error
+// if that is outside of root, as links from earlier entries could
+// then be used to write elsewhere.
+func resolveTarget(fsys FS, root string, target string, follow bool) (string, error) {
+	real := filepath.Join(realPath(fsys, filepath.Dir(target)), filepath.Base(target))
+	if follow {
+		real = realPath(fsys, real)
+	}
+	if !within(root, real) {
+		return "", fmt.Errorf("%s: path escapes destination through a symlink", target)
+	}
+	return real, nil
+}
+
+// checkLink rejects symlinks that point outside of root, the
+// destination with its symlinks resolved
+func checkLink(fsys FS, root string, target string, link string) error {
+	if filepath.IsAbs(link) {
+		return fmt.Errorf("%s: absolute symlink to %s", target, link)
+	}
+	dest := filepath.Dir(target) + string(filepath.Separator) + filepath.FromSlash(link)
+	if !within(root, realPath(fsys, dest)) {
+		return fmt.Errorf("%s: symlink escapes destination", target)
+	}
+	return nil
+}
+
+// within reports if path is dir or inside of it
+func within(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// writeFile creates a file with the given contents and permissions
+func writeFile(fsys FS, target string, r io.Reader, mode os.FileMode) error {
+	if err := fsys.MkdirAll(filepath.Dir(target), 0777); err != nil {
+		return err
+	}
+	// remove first so a symlink in the archive can not be
+	// used to write elsewhere
+	fsys.Remove(target)
+	out, err := fsys.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, r)
+	cerr := out.Close()
+	if err != nil {
+		return err
+	}
+	if cerr != nil {
+		return cerr
+	}
+	// umask may have removed bits
+	return fsys.Chmod(target, mode)
+}
+
+// Gzip compresses or decompresses files.
+//
+// Each file is replaced by a compressed "file.gz" unless -k is given.
+// With -c, or if there are no files, the output goes to stdout.  An
+// existing output file is only replaced with -f.
+func Gzip(s *Session, cli []string) error {
+	name, fargs := cli[0], cli[1:]
+	f := flag.NewFlagSet(name, flag.ContinueOnError)
+	flagDecompress := f.Bool("d", name == "gunzip", "decompress")
+	flagKeep := f.Bool("k", false, "keep input files")
+	flagStdout := f.Bool("c", false, "write to stdout, keep input files")
+	flagForce := f.Bool("f", false, "replace existing output files")
+	err := f.Parse(fargs)
+	if err != nil {
+		return err
+	}
+	args := f.Args()
+	if len(args) == 0 {
+		args = []string{"-"}
+	}
+	for _, fname := range args {
+		if err := gzipFile(s, fname, *flagDecompress, *flagKeep, *flagStdout, *flagForce); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+func gzipFile(s *Session, fname string, decompress bool, keep bool, stdout bool, force bool) error {
+	outname := "-"
+	if fname != "-" && !stdout {
+		if decompress {
+			if !strings.HasSuffix(fname, ".gz") {
+				return fmt.Errorf("%s: unknown suffix", fname)
+			}
+			outname = strings.TrimSuffix(fname, ".gz")
+		} else {
+			outname = fname + ".gz"
+		}
+		if _, err := s.FS.Lstat(s.Abs(outname)); err == nil && !force {
+			return fmt.Errorf("%s already exists", outname)
+		}
+	}
+
+	in, err := openInput(s, fname)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var info os.FileInfo
+	if fname != "-" {
+		if info, err = s.FS.Stat(s.Abs(fname)); err != nil {
+			return err
+		}
+	}
+
+	out, closer, err := createOutput(s, outname)
+	if err != nil {
+		return err
+	}
+	if decompress {
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(in); err == nil {
+			_, err = io.Copy(out, zr)
+		}
+	} else {
+		zw := gzip.NewWriter(out)
+		if info != nil {
+			zw.Name = filepath.Base(fname)
+			zw.ModTime = info.ModTime()
+		}
+		if _, err = io.Copy(zw, in); err == nil {
+			err = zw.Close()
+		}
+	}
+	if cerr := closer(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		if outname != "-" {
+			s.FS.Remove(s.Abs(outname))
+		}
+		return err
+	}
+	if outname == "-" {
+		return nil
+	}
+	s.FS.Chmod(s.Abs(outname), info.Mode().Perm())
+	s.FS.Chtimes(s.Abs(outname), info.ModTime(), info.ModTime())
+	if !keep {
+		return s.FS.Remove(s.Abs(fname))
+	}
+	return nil
+}
+
+// Zip creates a zip archive from files and directories.
+//
+//	zip [-r] out.zip paths...
+//
+// Directories are always added recursively, -r is accepted for
+// compatibility.
+func Zip(s *Session, cli []string) error {
+	name, fargs := cli[0], cli[1:]
+	f := flag.NewFlagSet(name, flag.ContinueOnError)
+	f.Bool("r", true, "recurse into directories")
+	err := f.Parse(fargs)
+	if err != nil {
+		return err
+	}
+	args := f.Args()
+	if len(args) < 2 {
+		return fmt.Errorf("%s: requires an archive name and files", name)
+	}
+	out, closer, err := createOutput(s, args[0])
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	err = zipCreate(s.FS, out, s.dir, args[1:])
+	if cerr := closer(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	return nil
+}
+
+func zipCreate(fsys FS, out io.Writer, dir string, paths []string) error {
+	zw := zip.NewWriter(out)
+	for _, p := range paths {
+		err := walk(fsys, joinDir(dir, p), func(fpath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			hdr, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return err
+			}
+			hdr.Name = archiveName(dir, fpath)
+			if info.IsDir() {
+				hdr.Name += "/"
+			} else {
+				hdr.Method = zip.Deflate
+			}
+			w, err := zw.CreateHeader(hdr)
+			if err != nil {
+				return err
+			}
+			if info.Mode()&os.ModeSymlink != 0 {
+				// symlinks are stored with the target as contents
+				link, err := fsys.Readlink(fpath)
+				if err != nil {
+					return err
+				}
+				_, err = io.WriteString(w, link)
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			in, err := openFile(fsys, fpath)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(w, in)
+			in.Close()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// Unzip extracts or lists a zip archive.
+//
+//	unzip [-l] [-d dir] [-strip-components N] in.zip
+func Unzip(s *Session, cli []string) error {
+	name, fargs := cli[0], cli[1:]
+	f := flag.NewFlagSet(name, flag.ContinueOnError)
+	flagDir := f.String("d", ".", "extract into directory")
+	flagList := f.Bool("l", false, "list archive contents")
+	flagStrip := f.Int("strip-components", 0, "remove leading path elements when extracting")
+	err := f.Parse(fargs)
+	if err != nil {
+		return err
+	}
+	args := f.Args()
+	if len(args) != 1 {
+		return fmt.Errorf("%s: requires exactly one archive", name)
+	}
+	in, err := openFile(s.FS, s.Abs(args[0]))
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	zr, err := zip.NewReader(in, info.Size())
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+
+	if *flagList {
+		for _, zf := range zr.File {
+			fmt.Fprintf(s.Stdout, "%10d  %s  %s\n", zf.UncompressedSize64,
+				zf.Modified.Format(time.DateTime), zf.Name)
+		}
+		return nil
+	}
+
+	dir := s.Abs(*flagDir)
+	root := realPath(s.FS, dir)
+	for _, zf := range zr.File {
+		if err := unzipFile(s.FS, zf, dir, root, *flagStrip); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// unzipFile extracts one entry into dir.  root is dir with its
+// symlinks resolved.
+func unzipFile(fsys FS, zf *zip.File, dir string, root string, strip int) error {
+	target, ok, err := extractPath(dir, zf.Name, strip)
+	if err != nil || !ok {
+		return err
+	}
+	mode := zf.Mode()
+	target, err = resolveTarget(fsys, root, target, mode.IsDir())
+	if err != nil {
+		return err
+	}
+	switch {
+	case mode.IsDir():
+		return fsys.MkdirAll(target, 0777)
+	case mode&os.ModeSymlink != 0:
+		r, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		link, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+		if err := checkLink(fsys, root, target, string(link)); err != nil {
+			return err
+		}
+		if err := fsys.MkdirAll(filepath.Dir(target), 0777); err != nil {
+			return err
+		}
+		fsys.Remove(target)
+		return fsys.Symlink(string(link), target)
+	default:
+		r, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		err = writeFile(fsys, target, r, mode.Perm())
+		r.Close()
+		if err != nil {
+			return err
+		}
+		fsys.Chtimes(target, zf.Modified, zf.Modified)
+		return nil
+	}
+}
@@ -0,0 +1,176 @@
+package gsh
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// entry is a file, directory or link in a test archive
+type entry struct {
+	name string
+	link string // symlink target
+	body string
+	dir  bool
+}
+
+func makeTar(t *testing.T, entries []entry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.body))}
+		switch {
+		case e.dir:
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0755
+		case e.link != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.link, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			tw.Write([]byte(e.body))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func makeZip(t *testing.T, entries []entry) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name}
+		body := e.body
+		switch {
+		case e.dir:
+			hdr.Name += "/"
+			hdr.SetMode(os.ModeDir | 0755)
+		case e.link != "":
+			hdr.SetMode(os.ModeSymlink | 0777)
+			body = e.link
+		default:
+			hdr.SetMode(0644)
+		}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(body))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+		ok      bool
+		file    string // relative to the destination, if ok
+	}{
+		{"plain", []entry{{name: "d", dir: true}, {name: "d/f", body: "x"}}, true, "d/f"},
+		{"inner link", []entry{{name: "d", dir: true}, {name: "l", link: "d"}, {name: "l/f", body: "x"}}, true, "d/f"},
+		{"dotdot", []entry{{name: "../pwned", body: "x"}}, false, ""},
+		{"absolute", []entry{{name: "/pwned", body: "x"}}, false, ""},
+		{"link out", []entry{{name: "a", link: ".."}}, false, ""},
+		{"absolute link", []entry{{name: "a", link: "/"}}, false, ""},
+		{"link then file", []entry{{name: "a", link: "../x"}, {name: "a/pwned", body: "x"}}, false, ""},
+		{"link chain", []entry{{name: "a", link: "."}, {name: "a/b", link: ".."}, {name: "a/b/pwned", body: "x"}}, false, ""},
+		{"link chain dir", []entry{{name: "a", link: "."}, {name: "a/b", link: ".."}, {name: "a/b/pwned", dir: true}}, false, ""},
+	}
+	for _, format := range []string{"tar", "zip"} {
+		for _, tt := range tests {
+			t.Run(format+"/"+tt.name, func(t *testing.T) {
+				top := t.TempDir()
+				dest := filepath.Join(top, "dest")
+				os.Mkdir(dest, 0755)
+				s := New()
+				s.Stderr = &bytes.Buffer{}
+				if err := s.Exec("cd " + top); err != nil {
+					t.Fatal(err)
+				}
+				var err error
+				if format == "tar" {
+					os.WriteFile(filepath.Join(top, "in.tar"), makeTar(t, tt.entries), 0644)
+					err = s.Exec("tar -x -f in.tar -C dest")
+				} else {
+					os.WriteFile(filepath.Join(top, "in.zip"), makeZip(t, tt.entries), 0644)
+					err = s.Exec("unzip -d dest in.zip")
+				}
+				if tt.ok != (err == nil) {
+					t.Fatalf("got error %v", err)
+				}
+				if tt.ok {
+					if _, err := os.Stat(filepath.Join(dest, tt.file)); err != nil {
+						t.Error(err)
+					}
+				}
+				if _, err := os.Lstat(filepath.Join(top, "pwned")); err == nil {
+					t.Error("wrote outside of the destination")
+				}
+			})
+		}
+	}
+}
+
+func TestGzip(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  []string // existing files, with "a.txt" holding "a"
+		script string
+		want   map[string]bool // files left afterwards
+		err    bool
+	}{
+		{"compress", nil, "gzip a.txt", map[string]bool{"a.txt.gz": true}, false},
+		{"keep", nil, "gzip -k a.txt", map[string]bool{"a.txt": true, "a.txt.gz": true}, false},
+		{"exists", []string{"a.txt.gz"}, "gzip a.txt", map[string]bool{"a.txt": true, "a.txt.gz": true}, true},
+		{"force", []string{"a.txt.gz"}, "gzip -f a.txt", map[string]bool{"a.txt.gz": true}, false},
+		{"round trip", nil, "gzip a.txt\ngunzip a.txt.gz", map[string]bool{"a.txt": true}, false},
+		{"gunzip exists", nil, "gzip -k a.txt\ngunzip a.txt.gz", map[string]bool{"a.txt": true, "a.txt.gz": true}, true},
+		{"gunzip force", nil, "gzip -k a.txt\ngunzip -f a.txt.gz", map[string]bool{"a.txt": true}, false},
+		{"stdout", []string{"a.txt.gz"}, "gzip -c a.txt", map[string]bool{"a.txt": true, "a.txt.gz": true}, false},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+		for _, name := range tt.files {
+			os.WriteFile(filepath.Join(dir, name), []byte("old"), 0644)
+		}
+		s := New()
+		s.Stdout = &bytes.Buffer{}
+		s.Exec("cd " + dir)
+		err := s.Exec(tt.script)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error %v", tt.name, err)
+		}
+		entries, _ := os.ReadDir(dir)
+		got := make(map[string]bool)
+		for _, e := range entries {
+			got[e.Name()] = true
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got files %v, want %v", tt.name, got, tt.want)
+		}
+		for name := range tt.want {
+			if !got[name] {
+				t.Errorf("%s: got files %v, want %v", tt.name, got, tt.want)
+			}
+		}
+		if b, _ := os.ReadFile(filepath.Join(dir, "a.txt")); got["a.txt"] && string(b) != "a" {
+			t.Errorf("%s: a.txt is %q", tt.name, b)
+		}
+		if tt.err {
+			if b, _ := os.ReadFile(filepath.Join(dir, "a.txt.gz")); tt.files != nil && string(b) != "old" {
+				t.Errorf("%s: existing a.txt.gz replaced", tt.name)
+			}
+		}
+	}
+}
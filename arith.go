@@ -0,0 +1,613 @@
+package gsh
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Arithmetic is done with signed 64-bit integers, as "$(( expr ))"
+// or with the "let" builtin.  The C operators are supported, from
+// lowest to highest precedence:
+//
+//	,
+//	= += -= *= /= %= <<= >>= &= ^= |=
+//	?:
+//	||
+//	&&
+//	|
+//	^
+//	&
+//	== !=
+//	< <= > >=
+//	<< >>
+//	+ -
+//	* / %
+//	**
+//	+ - ! ~ ++ --  (prefix)
+//	++ --          (postfix)
+//
+// Numbers may be written as 0x1f, 017 or base#digits.  Variables are
+// used by name, and an unset or empty variable is 0.  Overflow and
+// division by zero are errors instead of wrapping around.
+
+// arithExpr is a parsed arithmetic expression
+type arithExpr struct {
+	op      string // operator, "" for a number, "name" for a variable
+	val     int64
+	name    string
+	x, y, z *arithExpr
+}
+
+// arith evaluates an arithmetic expression
+func (s *Session) arith(expr string) (int64, error) {
+	p := &arithParser{src: expr}
+	if err := p.next(); err != nil {
+		return 0, fmt.Errorf("%s: %s", strings.TrimSpace(expr), err)
+	}
+	if p.tok == "" {
+		return 0, nil
+	}
+	e, err := p.comma()
+	if err == nil && p.tok != "" {
+		err = fmt.Errorf("syntax error: unexpected %q", p.tok)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s", strings.TrimSpace(expr), err)
+	}
+	n, err := s.evalArith(e)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s", strings.TrimSpace(expr), err)
+	}
+	return n, nil
+}
+
+// arithOps are the operator tokens, longest first
+var arithOps = []string{
+	"<<=", ">>=", "**",
+	"<<", ">>", "<=", ">=", "==", "!=", "&&", "||", "++", "--",
+	"+=", "-=", "*=", "/=", "%=", "&=", "^=", "|=",
+	"+", "-", "*", "/", "%", "<", ">", "&", "^", "|", "!", "~",
+	"=", "?", ":", ",", "(", ")",
+}
+
+// arithParser is a recursive descent parser for arithmetic
+type arithParser struct {
+	src  string
+	pos  int
+	tok  string // current token, "" at the end
+	num  int64  // value if tok is a number
+	kind byte   // 'n' number, 'v' variable, 'o' operator
+}
+
+// next reads the next token
+func (p *arithParser) next() error {
+	for p.pos < len(p.src) && strings.IndexByte(" \t\r\n", p.src[p.pos]) != -1 {
+		p.pos++
+	}
+	p.tok, p.kind = "", 0
+	if p.pos == len(p.src) {
+		return nil
+	}
+	start := p.pos
+	switch c := p.src[p.pos]; {
+	case isDigit(c):
+		for p.pos < len(p.src) && (isNameChar(p.src[p.pos]) || p.src[p.pos] == '#' || p.src[p.pos] == '@') {
+			p.pos++
+		}
+		p.tok, p.kind = p.src[start:p.pos], 'n'
+		n, err := parseArithNumber(p.tok)
+		if err != nil {
+			return err
+		}
+		p.num = n
+		return nil
+	case isNameStart(c):
+		for p.pos < len(p.src) && isNameChar(p.src[p.pos]) {
+			p.pos++
+		}
+		p.tok, p.kind = p.src[start:p.pos], 'v'
+		return nil
+	}
+	for _, op := range arithOps {
+		if strings.HasPrefix(p.src[p.pos:], op) {
+			p.pos += len(op)
+			p.tok, p.kind = op, 'o'
+			return nil
+		}
+	}
+	return fmt.Errorf("syntax error: invalid character %q", p.src[p.pos])
+}
+
+// parseArithNumber parses 10, 0x1f, 017 or base#digits
+func parseArithNumber(tok string) (int64, error) {
+	base := 10
+	digits := tok
+	switch {
+	case strings.Contains(tok, "#"):
+		b, d, _ := strings.Cut(tok, "#")
+		n, err := strconv.Atoi(b)
+		if err != nil || n < 2 || n > 64 {
+			return 0, fmt.Errorf("%s: invalid arithmetic base", tok)
+		}
+		base, digits = n, d
+	case strings.HasPrefix(tok, "0x") || strings.HasPrefix(tok, "0X"):
+		base, digits = 16, tok[2:]
+	case len(tok) > 1 && tok[0] == '0':
+		base, digits = 8, tok[1:]
+	}
+	if digits == "" {
+		return 0, fmt.Errorf("%s: invalid number", tok)
+	}
+	var n int64
+	for i := 0; i < len(digits); i++ {
+		d := digitValue(digits[i], base)
+		if d < 0 || d >= base {
+			return 0, fmt.Errorf("%s: value too great for base", tok)
+		}
+		if n > (math.MaxInt64-int64(d))/int64(base) {
+			return 0, fmt.Errorf("%s: arithmetic overflow", tok)
+		}
+		n = n*int64(base) + int64(d)
+	}
+	return n, nil
+}
+
+// digitValue is the value of a digit in bash's base#digits notation
+func digitValue(c byte, base int) int {
+	switch {
+	case isDigit(c):
+		return int(c - '0')
+	case c >= 'a' && c <= 'z':
+		return int(c-'a') + 10
+	case c >= 'A' && c <= 'Z':
+		if base <= 36 {
+			return int(c-'A') + 10
+		}
+		return int(c-'A') + 36
+	case c == '@':
+		return 62
+	case c == '_':
+		return 63
+	}
+	return -1
+}
+
+func (p *arithParser) expect(tok string) error {
+	if p.tok != tok {
+		if p.tok == "" {
+			return fmt.Errorf("syntax error: missing %q", tok)
+		}
+		return fmt.Errorf("syntax error: expected %q, found %q", tok, p.tok)
+	}
+	return p.next()
+}
+
+func (p *arithParser) comma() (*arithExpr, error) {
+	x, err := p.assign()
+	for err == nil && p.tok == "," {
+		if err = p.next(); err != nil {
+			break
+		}
+		var y *arithExpr
+		y, err = p.assign()
+		x = &arithExpr{op: ",", x: x, y: y}
+	}
+	return x, err
+}
+
+func (p *arithParser) assign() (*arithExpr, error) {
+	x, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	switch p.tok {
+	case "=", "+=", "-=", "*=", "/=", "%=", "<<=", ">>=", "&=", "^=", "|=":
+		if x.op != "name" {
+			return nil, fmt.Errorf("attempted assignment to non-variable")
+		}
+		op := p.tok
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		y, err := p.assign()
+		if err != nil {
+			return nil, err
+		}
+		return &arithExpr{op: op, name: x.name, y: y}, nil
+	}
+	return x, nil
+}
+
+func (p *arithParser) ternary() (*arithExpr, error) {
+	x, err := p.binary(0)
+	if err != nil || p.tok != "?" {
+		return x, err
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	y, err := p.comma()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	z, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	return &arithExpr{op: "?", x: x, y: y, z: z}, nil
+}
+
+// binaryOps are the left associative operators by precedence
+var binaryOps = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *arithParser) binary(level int) (*arithExpr, error) {
+	if level == len(binaryOps) {
+		return p.power()
+	}
+	x, err := p.binary(level + 1)
+	for err == nil && p.kind == 'o' && containsString(binaryOps[level], p.tok) {
+		op := p.tok
+		if err = p.next(); err != nil {
+			break
+		}
+		var y *arithExpr
+		y, err = p.binary(level + 1)
+		x = &arithExpr{op: op, x: x, y: y}
+	}
+	return x, err
+}
+
+func containsString(list []string, str string) bool {
+	for _, v := range list {
+		if v == str {
+			return true
+		}
+	}
+	return false
+}
+
+// power is right associative, so 2**3**2 is 2**9
+func (p *arithParser) power() (*arithExpr, error) {
+	x, err := p.unary()
+	if err != nil || p.tok != "**" {
+		return x, err
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	y, err := p.power()
+	if err != nil {
+		return nil, err
+	}
+	return &arithExpr{op: "**", x: x, y: y}, nil
+}
+
+func (p *arithParser) unary() (*arithExpr, error) {
+	switch op := p.tok; op {
+	case "+", "-", "!", "~":
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &arithExpr{op: "u" + op, x: x}, nil
+	case "++", "--":
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if x.op != "name" {
+			return nil, fmt.Errorf("%s requires a variable", op)
+		}
+		return &arithExpr{op: "pre" + op, name: x.name}, nil
+	}
+	return p.postfix()
+}
+
+func (p *arithParser) postfix() (*arithExpr, error) {
+	var x *arithExpr
+	switch p.kind {
+	case 'n':
+		x = &arithExpr{val: p.num}
+	case 'v':
+		x = &arithExpr{op: "name", name: p.tok}
+	default:
+		if p.tok != "(" {
+			if p.tok == "" {
+				return nil, fmt.Errorf("syntax error: operand expected")
+			}
+			return nil, fmt.Errorf("syntax error: unexpected %q", p.tok)
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		x, err := p.comma()
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(")")
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if x.op == "name" && (p.tok == "++" || p.tok == "--") {
+		x = &arithExpr{op: "post" + p.tok, name: x.name}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	return x, nil
+}
+
+// arithVar returns the value of a variable used in arithmetic
+func (s *Session) arithVar(name string) (int64, error) {
+	val, ok := s.lookupVar(name)
+	if !ok && s.opts.nounset {
+		return 0, fmt.Errorf("%s: unbound variable", name)
+	}
+	val = strings.TrimSpace(val)
+	if val == "" {
+		return 0, nil
+	}
+	neg := strings.HasPrefix(val, "-")
+	n, err := parseArithNumber(strings.TrimPrefix(strings.TrimPrefix(val, "-"), "+"))
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid number %q", name, val)
+	}
+	if neg {
+		n = -n
+	}
+	return n, nil
+}
+
+func (s *Session) evalArith(e *arithExpr) (int64, error) {
+	switch e.op {
+	case "":
+		return e.val, nil
+	case "name":
+		return s.arithVar(e.name)
+	case "pre++", "pre--", "post++", "post--":
+		old, err := s.arithVar(e.name)
+		if err != nil {
+			return 0, err
+		}
+		n, err := arithOp(e.op[len(e.op)-1:], old, 1)
+		if err != nil {
+			return 0, err
+		}
+		if err := s.SetVar(e.name, strconv.FormatInt(n, 10)); err != nil {
+			return 0, err
+		}
+		if strings.HasPrefix(e.op, "post") {
+			return old, nil
+		}
+		return n, nil
+	case "=", "+=", "-=", "*=", "/=", "%=", "<<=", ">>=", "&=", "^=", "|=":
+		n, err := s.evalArith(e.y)
+		if err != nil {
+			return 0, err
+		}
+		if e.op != "=" {
+			old, err := s.arithVar(e.name)
+			if err != nil {
+				return 0, err
+			}
+			if n, err = arithOp(strings.TrimSuffix(e.op, "="), old, n); err != nil {
+				return 0, err
+			}
+		}
+		if err := s.SetVar(e.name, strconv.FormatInt(n, 10)); err != nil {
+			return 0, err
+		}
+		return n, nil
+	case "?":
+		x, err := s.evalArith(e.x)
+		if err != nil {
+			return 0, err
+		}
+		if x != 0 {
+			return s.evalArith(e.y)
+		}
+		return s.evalArith(e.z)
+	case "&&", "||":
+		x, err := s.evalArith(e.x)
+		if err != nil {
+			return 0, err
+		}
+		if (x != 0) == (e.op == "||") {
+			return boolInt(x != 0), nil
+		}
+		y, err := s.evalArith(e.y)
+		return boolInt(y != 0), err
+	case "u+", "u-", "u!", "u~":
+		x, err := s.evalArith(e.x)
+		if err != nil {
+			return 0, err
+		}
+		switch e.op {
+		case "u-":
+			if x == math.MinInt64 {
+				return 0, fmt.Errorf("arithmetic overflow")
+			}
+			return -x, nil
+		case "u!":
+			return boolInt(x == 0), nil
+		case "u~":
+			return ^x, nil
+		}
+		return x, nil
+	}
+	x, err := s.evalArith(e.x)
+	if err != nil {
+		return 0, err
+	}
+	y, err := s.evalArith(e.y)
+	if err != nil {
+		return 0, err
+	}
+	return arithOp(e.op, x, y)
+}
+
+// arithOp applies a binary operator, checking for overflow
+func arithOp(op string, x, y int64) (int64, error) {
+	overflow := fmt.Errorf("arithmetic overflow")
+	switch op {
+	case ",":
+		return y, nil
+	case "+":
+		n := x + y
+		if (n > x) != (y > 0) {
+			return 0, overflow
+		}
+		return n, nil
+	case "-":
+		n := x - y
+		if (n < x) != (y > 0) {
+			return 0, overflow
+		}
+		return n, nil
+	case "*":
+		if x == 0 || y == 0 {
+			return 0, nil
+		}
+		n := x * y
+		if n/y != x || (x == -1 && y == math.MinInt64) || (y == -1 && x == math.MinInt64) {
+			return 0, overflow
+		}
+		return n, nil
+	case "/", "%":
+		if y == 0 {
+			return 0, fmt.Errorf("division by 0")
+		}
+		if x == math.MinInt64 && y == -1 {
+			if op == "%" {
+				return 0, nil
+			}
+			return 0, overflow
+		}
+		if op == "/" {
+			return x / y, nil
+		}
+		return x % y, nil
+	case "**":
+		if y < 0 {
+			return 0, fmt.Errorf("exponent less than 0")
+		}
+		// square and multiply
+		n := int64(1)
+		for base := x; y > 0; {
+			var err error
+			if y&1 == 1 {
+				if n, err = arithOp("*", n, base); err != nil {
+					return 0, err
+				}
+			}
+			if y >>= 1; y > 0 {
+				if base, err = arithOp("*", base, base); err != nil {
+					return 0, err
+				}
+			}
+		}
+		return n, nil
+	case "<<", ">>":
+		if y < 0 || y > 63 {
+			return 0, fmt.Errorf("shift count %d out of range", y)
+		}
+		if op == ">>" {
+			return x >> uint(y), nil
+		}
+		n := x << uint(y)
+		if n>>uint(y) != x {
+			return 0, overflow
+		}
+		return n, nil
+	case "&":
+		return x & y, nil
+	case "|":
+		return x | y, nil
+	case "^":
+		return x ^ y, nil
+	case "==":
+		return boolInt(x == y), nil
+	case "!=":
+		return boolInt(x != y), nil
+	case "<":
+		return boolInt(x < y), nil
+	case "<=":
+		return boolInt(x <= y), nil
+	case ">":
+		return boolInt(x > y), nil
+	case ">=":
+		return boolInt(x >= y), nil
+	}
+	return 0, fmt.Errorf("syntax error: unknown operator %q", op)
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// matchArith returns the index just after the "))" closing the
+// "$((" at str[i], or -1 if there is none.
+func matchArith(str string, i int) int {
+	depth := 0
+	for j := i + 3; j < len(str); j++ {
+		switch str[j] {
+		case '(':
+			depth++
+		case ')':
+			if depth > 0 {
+				depth--
+				continue
+			}
+			if j+1 < len(str) && str[j+1] == ')' {
+				return j + 2
+			}
+			return -1
+		}
+	}
+	return -1
+}
+
+// Let evaluates arithmetic expressions, usually to set variables.
+//
+//	let "i = i + 1" j++ ...
+//
+// Unlike bash, a result of 0 is not a failure, since a failing
+// command stops the script.
+func Let(s *Session, cli []string) error {
+	name, args := cli[0], cli[1:]
+	if len(args) == 0 {
+		return fmt.Errorf("%s: expression expected", name)
+	}
+	for _, arg := range args {
+		if _, err := s.arith(arg); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
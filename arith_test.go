@@ -0,0 +1,93 @@
+package gsh
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestArith(t *testing.T) {
+	tests := []struct {
+		expr string
+		want int64
+		err  bool
+	}{
+		{"1 + 2 * 3", 7, false},
+		{"(1 + 2) * 3", 9, false},
+		{"7 / 2", 3, false},
+		{"-7 % 3", -1, false},
+		{"1 << 4", 16, false},
+		{"2 ** 10", 1024, false},
+		{"2 ** 3 ** 2", 512, false},
+		{"2 ** 0", 1, false},
+		{"2 ** 1", 2, false},
+		{"0 ** 0", 1, false},
+		{"0 ** 1", 0, false},
+		{"0 ** 5", 0, false},
+		{"1 ** 100", 1, false},
+		{"(-1) ** 0", 1, false},
+		{"(-1) ** 1", -1, false},
+		{"(-1) ** 2", 1, false},
+		{"(-1) ** 3", -1, false},
+		{"(-2) ** 3", -8, false},
+		{"(-3) ** 4", 81, false},
+		{"(-2) ** 63", -9223372036854775808, false},
+		{"2 ** 62", 4611686018427387904, false},
+		{"2 ** 63", 0, true},
+		{"3 ** 40", 0, true},
+		{"(-1) ** 9223372036854775807", -1, false},
+		{"2 ** -1", 0, true},
+		{"1 / 0", 0, true},
+		{"9223372036854775807 + 1", 0, true},
+		{"x = 5, x * 2", 10, false},
+		{"1 ? 2 : 3", 2, false},
+		{"1 +", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := New().arith(tt.expr)
+		switch {
+		case tt.err && err == nil:
+			t.Errorf("%s: got %d, want an error", tt.expr, got)
+		case !tt.err && err != nil:
+			t.Errorf("%s: %v", tt.expr, err)
+		case got != tt.want:
+			t.Errorf("%s: got %d, want %d", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestArithExpansion(t *testing.T) {
+	tests := []struct {
+		script string
+		want   string
+		err    bool
+	}{
+		{"echo $((1 + 2 * 3))", "7", false},
+		{"x=4\necho $((x * 2)) $(($x + 1))", "8 5", false},
+		{"echo $((unset + 1))", "1", false},
+		{"echo \"$((2 ** 4))\"", "16", false},
+		{"echo a$((1+1))b", "a2b", false},
+		{"echo $(( (1 + 2) * 3 ))", "9", false},
+		{"x=1\necho $((x += 2)) $x", "3 3", false},
+		{"i=0\nlet i++ \"i = i * 10\"\necho $i", "10", false},
+		{"let x=0\necho $x", "0", false},
+		{"echo $((1 / 0))", "", true},
+		{"echo $((1 +))", "", true},
+		{"echo $((1 + 2)", "", true},
+		{"let", "", true},
+		{"let '1 +'", "", true},
+	}
+	for _, tt := range tests {
+		s := New()
+		delete(s.Env, "unset")
+		var out strings.Builder
+		s.Stdout = &out
+		err := s.Exec(tt.script)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: error %v", tt.script, err)
+			continue
+		}
+		if out.String() != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.script, out.String(), tt.want)
+		}
+	}
+}
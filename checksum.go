@@ -0,0 +1,147 @@
+package gsh
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// Md5sum prints or checks MD5 checksums, compatible with md5sum
+func Md5sum(s *Session, cli []string) error {
+	return checksum(s, cli, md5.New)
+}
+
+// Sha1sum prints or checks SHA1 checksums, compatible with sha1sum
+func Sha1sum(s *Session, cli []string) error {
+	return checksum(s, cli, sha1.New)
+}
+
+// Sha256sum prints or checks SHA256 checksums, compatible with sha256sum
+func Sha256sum(s *Session, cli []string) error {
+	return checksum(s, cli, sha256.New)
+}
+
+// Sha512sum prints or checks SHA512 checksums, compatible with sha512sum
+func Sha512sum(s *Session, cli []string) error {
+	return checksum(s, cli, sha512.New)
+}
+
+// checksum implements the *sum family of commands.
+//
+// Without -c each file (or stdin if none) is hashed and printed
+// as "HEX  NAME".  With -c the files are read as checklists of
+// that same format, and every listed file is verified.
+func checksum(s *Session, cli []string, newHash func() hash.Hash) error {
+	name, fargs := cli[0], cli[1:]
+	f := flag.NewFlagSet(name, flag.ContinueOnError)
+	flagCheck := f.Bool("c", false, "read checksums from the files and check them")
+	flagQuiet := f.Bool("quiet", false, "don't print OK for each successfully verified file")
+	err := f.Parse(fargs)
+	if err != nil {
+		return err
+	}
+	args := f.Args()
+	if len(args) == 0 {
+		args = []string{"-"}
+	}
+
+	if !*flagCheck {
+		for _, fname := range args {
+			sum, err := hashInput(s, newHash(), fname)
+			if err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+			fmt.Fprintf(s.Stdout, "%s  %s\n", sum, fname)
+		}
+		return nil
+	}
+
+	failed := 0
+	for _, list := range args {
+		r, err := openInput(s, list)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		scanner := bufio.NewScanner(r)
+		lineno := 0
+		for scanner.Scan() {
+			lineno++
+			line := scanner.Text()
+			if len(strings.TrimSpace(line)) == 0 {
+				continue
+			}
+			want, fname, ok := parseChecksumLine(line)
+			if !ok {
+				r.Close()
+				return fmt.Errorf("%s: %s:%d: improperly formatted checksum line", name, list, lineno)
+			}
+			sum, err := hashInput(s, newHash(), fname)
+			switch {
+			case err != nil:
+				failed++
+				fmt.Fprintf(s.Stdout, "%s: FAILED open or read\n", fname)
+			case !strings.EqualFold(sum, want):
+				failed++
+				fmt.Fprintf(s.Stdout, "%s: FAILED\n", fname)
+			case !*flagQuiet:
+				fmt.Fprintf(s.Stdout, "%s: OK\n", fname)
+			}
+		}
+		r.Close()
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("%s: %s: %s", name, list, err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%s: WARNING: %d computed checksum(s) did NOT match", name, failed)
+	}
+	return nil
+}
+
+// parseChecksumLine splits "HEX  NAME" or "HEX *NAME"
+func parseChecksumLine(line string) (string, string, bool) {
+	idx := strings.IndexByte(line, ' ')
+	if idx < 1 || idx+2 > len(line) {
+		return "", "", false
+	}
+	sum, fname := line[:idx], line[idx+2:]
+	if line[idx+1] != ' ' && line[idx+1] != '*' {
+		return "", "", false
+	}
+	if _, err := hex.DecodeString(sum); err != nil {
+		return "", "", false
+	}
+	return sum, fname, true
+}
+
+// openInput opens a file, or stdin if the name is "-"
+func openInput(s *Session, fname string) (io.ReadCloser, error) {
+	if fname != "-" {
+		return openFile(s.FS, s.Abs(fname))
+	}
+	if s.Stdin == nil {
+		return nil, fmt.Errorf("no input")
+	}
+	return io.NopCloser(s.Stdin), nil
+}
+
+// hashInput returns the hex digest of a file, or stdin if the name is "-"
+func hashInput(s *Session, h hash.Hash, fname string) (string, error) {
+	r, err := openInput(s, fname)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
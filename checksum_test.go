@@ -0,0 +1,75 @@
+package gsh
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChecksumCheck(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello world"), 0644)
+	const sum = "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+	const bad = "0000000000000000000000000000000000000000000000000000000000000000"
+
+	tests := []struct {
+		name string
+		list string
+		args string
+		want string
+		err  bool
+	}{
+		{"ok", sum + "  a.txt\n", "", "a.txt: OK\n", false},
+		{"binary", sum + " *a.txt\n", "", "a.txt: OK\n", false},
+		{"upper case", strings.ToUpper(sum) + "  a.txt\n", "", "a.txt: OK\n", false},
+		{"quiet", sum + "  a.txt\n", "-quiet", "", false},
+		{"mismatch", bad + "  a.txt\n", "", "a.txt: FAILED\n", true},
+		{"missing", sum + "  b.txt\n", "", "b.txt: FAILED open or read\n", true},
+		{"some failed", sum + "  a.txt\n" + bad + "  a.txt\n", "-quiet", "a.txt: FAILED\n", true},
+		{"blank lines", "\n" + sum + "  a.txt\n\n", "", "a.txt: OK\n", false},
+		{"malformed", "not a checksum line\n", "", "", true},
+		{"one space", sum + " a.txt\n", "", "", true},
+		{"no name", sum + "\n", "", "", true},
+	}
+	for _, tt := range tests {
+		os.WriteFile(filepath.Join(dir, "SUMS"), []byte(tt.list), 0644)
+		s := New()
+		var out strings.Builder
+		s.Stdout = &out
+		s.Exec("cd " + dir)
+		err := s.Exec("sha256sum -c " + tt.args + " SUMS")
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error %v", tt.name, err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, out.String(), tt.want)
+		}
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello world"), 0644)
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"md5sum a.txt", "5eb63bbbe01eeed093cb22bb8f5acdc3  a.txt\n"},
+		{"sha1sum a.txt", "2aae6c35c94fcfb415dbe95f408b9ce91ee846ed  a.txt\n"},
+		{"sha256sum a.txt", "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9  a.txt\n"},
+	}
+	for _, tt := range tests {
+		s := New()
+		var out strings.Builder
+		s.Stdout = &out
+		s.Exec("cd " + dir)
+		if err := s.Exec(tt.cmd); err != nil {
+			t.Errorf("%s: %v", tt.cmd, err)
+			continue
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.cmd, out.String(), tt.want)
+		}
+	}
+}
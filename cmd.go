@@ -0,0 +1,273 @@
+package gsh
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// cmdKind is the type of a Cmd
+type cmdKind int
+
+const (
+	cmdSimple cmdKind = iota // a single command and args
+	cmdPipe                  // stdout of each command is stdin of the next
+	cmdAnd                   // run while commands succeed
+	cmdOr                    // run until a command succeeds
+)
+
+// Cmd is a command built from Go values instead of a script.  The
+// args are used exactly as given, with no expansion, splitting or
+// globbing, so untrusted file names are safe.  The command is run
+// in the same way as a script, so aliases, functions and builtins
+// all work.
+//
+//	err := s.Cmd("cp", "-r", src, dst).Dir(build).Run()
+//
+// Settings made with Stdin, Stdout, Stderr, Env, Limits and Dir
+// last only for the command, and the session is left as it was.
+type Cmd struct {
+	s      *Session
+	kind   cmdKind
+	args   []string
+	cmds   []*Cmd
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	env    map[string]string
+	dir    string
+	limits *Limits
+}
+
+// Cmd makes a command to run in the session
+func (s *Session) Cmd(name string, args ...string) *Cmd {
+	return &Cmd{
+		s:    s,
+		kind: cmdSimple,
+		args: append([]string{name}, args...),
+	}
+}
+
+// Pipe connects the output of each command to the input of the
+// next, and runs them at the same time.  Each command runs in a
+// clone of the session, like a subshell.  The error is from the
+// last command that failed, so a failure early in the pipeline is
+// not hidden.
+func (s *Session) Pipe(cmds ...*Cmd) *Cmd {
+	return &Cmd{s: s, kind: cmdPipe, cmds: cmds}
+}
+
+// And runs commands in order, stopping at the first failure, like
+// "a && b" in the shell.
+func (s *Session) And(cmds ...*Cmd) *Cmd {
+	return &Cmd{s: s, kind: cmdAnd, cmds: cmds}
+}
+
+// Or runs commands in order until one succeeds, like "a || b" in
+// the shell.  The error is from the last command.
+func (s *Session) Or(cmds ...*Cmd) *Cmd {
+	return &Cmd{s: s, kind: cmdOr, cmds: cmds}
+}
+
+// Stdin sets the input of the command
+func (c *Cmd) Stdin(r io.Reader) *Cmd {
+	c.stdin = r
+	return c
+}
+
+// Stdout sets where output of the command goes
+func (c *Cmd) Stdout(w io.Writer) *Cmd {
+	c.stdout = w
+	return c
+}
+
+// Stderr sets where errors of the command go
+func (c *Cmd) Stderr(w io.Writer) *Cmd {
+	c.stderr = w
+	return c
+}
+
+// Env sets and exports a variable for the command
+func (c *Cmd) Env(key string, val string) *Cmd {
+	if c.env == nil {
+		c.env = make(map[string]string)
+	}
+	c.env[key] = val
+	return c
+}
+
+// Limits sets the resource limits of external commands run by the
+// command
+func (c *Cmd) Limits(l Limits) *Cmd {
+	c.limits = &l
+	return c
+}
+
+// Dir sets the working directory of the command.  A relative
+// directory is relative to the session working directory.  Like cd,
+// it can not leave the root of a Policy.
+func (c *Cmd) Dir(dir string) *Cmd {
+	c.dir = dir
+	return c
+}
+
+// String is the command as it would be written in a script
+func (c *Cmd) String() string {
+	var sep string
+	switch c.kind {
+	case cmdSimple:
+		args := make([]string, len(c.args))
+		for i, arg := range c.args {
+			args[i] = quoteArg(arg)
+		}
+		return strings.Join(args, " ")
+	case cmdPipe:
+		sep = " | "
+	case cmdAnd:
+		sep = " && "
+	case cmdOr:
+		sep = " || "
+	}
+	parts := make([]string, len(c.cmds))
+	for i, cmd := range c.cmds {
+		parts[i] = cmd.String()
+		if cmd.kind != cmdSimple {
+			parts[i] = "{ " + parts[i] + "; }"
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+// quoteArg quotes an arg if the shell would change it
+func quoteArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\r\n'\"\\$*?[]{}()<>&;|#~`") {
+		return arg
+	}
+	return quoteValue(arg)
+}
+
+// Run runs the command.  Like Exec, nothing is run if the session
+// already has an error, and a failure is kept as the session error.
+func (c *Cmd) Run() error {
+	if err := c.s.Error(); err != nil {
+		return err
+	}
+	if err := c.run(c.s); err != nil {
+		c.s.SetError(err)
+		return err
+	}
+	return nil
+}
+
+// run runs the command in s with the settings applied
+func (c *Cmd) run(s *Session) error {
+	if c.stdin != nil {
+		defer func(r io.Reader) { s.Stdin = r }(s.Stdin)
+		s.Stdin = c.stdin
+	}
+	if c.stdout != nil {
+		defer func(w io.Writer) { s.Stdout = w }(s.Stdout)
+		s.Stdout = c.stdout
+	}
+	if c.stderr != nil {
+		defer func(w io.Writer) { s.Stderr = w }(s.Stderr)
+		s.Stderr = c.stderr
+	}
+	if c.limits != nil {
+		defer func(l Limits) { s.Limits = l }(s.Limits)
+		s.Limits = *c.limits
+	}
+	if c.dir != "" {
+		dir := s.Abs(c.dir)
+		if err := s.policy.checkDir(s.FS, c.dir, dir); err != nil {
+			return err
+		}
+		defer func(dir string) { s.dir = dir }(s.dir)
+		s.dir = dir
+	}
+	if len(c.env) > 0 {
+		restore, err := s.override(c.env)
+		if err != nil {
+			return err
+		}
+		defer restore()
+	}
+
+	switch c.kind {
+	case cmdPipe:
+		return s.runPipe(c.cmds)
+	case cmdAnd, cmdOr:
+		var err error
+		for _, cmd := range c.cmds {
+			err = cmd.run(s)
+			if (err == nil) == (c.kind == cmdOr) {
+				break
+			}
+		}
+		return err
+	}
+	if len(c.args) == 0 || c.args[0] == "" {
+		return errors.New("empty command")
+	}
+	return s.runArgs(c.String(), c.args, false)
+}
+
+// runPipe runs commands connected by pipes, each in a clone.
+// Real pipes are used so an external command writing to a command
+// that has finished gets SIGPIPE, as in the shell.
+func (s *Session) runPipe(cmds []*Cmd) error {
+	if len(cmds) == 0 {
+		return nil
+	}
+	errs := make([]error, len(cmds))
+	group := s.pgroup
+	if group == nil {
+		group = &pgroup{}
+	}
+	var wg sync.WaitGroup
+	var in *os.File // read end of the pipe from the previous command
+	for i, cmd := range cmds {
+		c := s.Clone()
+		c.pgroup = group
+		if in != nil {
+			c.Stdin = in
+		}
+		var out, next *os.File
+		if i < len(cmds)-1 {
+			r, w, err := os.Pipe()
+			if err != nil {
+				errs[i] = err
+				if in != nil {
+					in.Close()
+				}
+				break
+			}
+			c.Stdout, out, next = w, w, r
+		}
+		wg.Add(1)
+		go func(i int, cmd *Cmd, c *Session, in, out *os.File) {
+			defer wg.Done()
+			errs[i] = cmd.run(c)
+			c.Close()
+			if out != nil {
+				out.Close()
+			}
+			if in != nil {
+				in.Close()
+			}
+		}(i, cmd, c, in, out)
+		in = next
+	}
+	wg.Wait()
+
+	var err error
+	for _, e := range errs {
+		// earlier commands may fail writing to a finished one
+		if e != nil && !strings.Contains(e.Error(), "broken pipe") {
+			err = e
+		}
+	}
+	return err
+}
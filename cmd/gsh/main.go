@@ -1,38 +1,67 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
 
 	"github.com/client9/gsh"
-	"gopkg.in/pipe.v2"
 )
 
-
 func main() {
+	gsh.ExecLimits()
+	if len(os.Args) > 1 && os.Args[1] == "test" {
+		log.SetOutput(io.Discard)
+		os.Exit(runTests(os.Args[2:]))
+	}
 
+	flagCmd := flag.String("c", "", "run the commands given instead of a file")
+	flagVerbose := flag.Bool("v", false, "log commands as they run")
 	flag.Parse()
-	args := flag.Args()
-	if len(args) == 0 {
-		log.Fatalf("OOPS no command")
+	if !*flagVerbose {
+		log.SetOutput(io.Discard)
 	}
 
-	cmd, ok := gsh.CmdMap[args[0]]
-	if !ok {
-		log.Fatalf("Unknown command %s", args[0])
+	name, script := "-c", *flagCmd
+	if script == "" {
+		args := flag.Args()
+		if len(args) != 1 {
+			fmt.Fprintf(os.Stderr, "usage: gsh [-c commands | file]\n       gsh test [-update] files...\n")
+			os.Exit(2)
+		}
+		name = args[0]
+		raw, err := os.ReadFile(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "gsh: %s\n", err)
+			os.Exit(1)
+		}
+		script = string(raw)
 	}
 
-	p := cmd(args[1:]...)
-
-	s := pipe.NewState(os.Stdout, os.Stderr)
+	s := gsh.New()
 	s.Stdin = os.Stdin
-	err := p(s)
+	s.Stdout = os.Stdout
+	s.Stderr = os.Stderr
+	stop := s.HandleSignals()
+	err := s.Exec(script)
+	s.Close()
+	stop()
 	if err == nil {
-		err = s.RunTasks()
+		return
 	}
-	if err != nil {
-		os.Stderr.WriteString(err.Error() + "\n")
+
+	// compiler style, so editors can jump to the line
+	var serr *gsh.ScriptError
+	if errors.As(err, &serr) {
+		fmt.Fprintf(os.Stderr, "%s:%d: %s\n", name, serr.Line, serr.Message())
+		if serr.ExitCode > 0 {
+			os.Exit(serr.ExitCode)
+		}
 		os.Exit(1)
 	}
+	fmt.Fprintf(os.Stderr, "%s: %s\n", name, err)
+	os.Exit(1)
 }
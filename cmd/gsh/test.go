@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/client9/gsh/gshtest"
+)
+
+// runTests is "gsh test [-update] files...", which checks scripts
+// against their golden files, see package gshtest.
+func runTests(args []string) int {
+	f := flag.NewFlagSet("gsh test", flag.ExitOnError)
+	flagUpdate := f.Bool("update", false, "rewrite the golden files")
+	f.Parse(args)
+	if f.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "usage: gsh test [-update] files...\n")
+		return 2
+	}
+	status := 0
+	for _, fname := range f.Args() {
+		diff, err := gshtest.Check(fname, *flagUpdate)
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "FAIL %s: %s\n", fname, err)
+			status = 1
+		case diff != "":
+			fmt.Printf("FAIL %s\n%s", fname, diff)
+			status = 1
+		default:
+			fmt.Printf("ok   %s\n", fname)
+		}
+	}
+	return status
+}
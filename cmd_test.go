@@ -0,0 +1,139 @@
+package gsh
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// runFuncs has a "run" builtin that records its args, and fails
+// with the status in its first arg, if it is a number
+func runFuncs(ran *[]string) FuncMap {
+	return FuncMap{
+		"run": func(s *Session, cli []string) error {
+			*ran = append(*ran, strings.Join(cli[1:], " "))
+			if len(cli) > 1 {
+				if n, err := strconv.Atoi(cli[1]); err == nil && n != 0 {
+					return ExitStatus(n)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func TestAndOr(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  func(s *Session) *Cmd
+		ran  string
+		code int
+	}{
+		{"and all", func(s *Session) *Cmd {
+			return s.And(s.Cmd("run", "a"), s.Cmd("run", "b"), s.Cmd("run", "c"))
+		}, "a|b|c", 0},
+		{"and stops", func(s *Session) *Cmd {
+			return s.And(s.Cmd("run", "a"), s.Cmd("run", "3"), s.Cmd("run", "c"))
+		}, "a|3", 3},
+		{"and first fails", func(s *Session) *Cmd {
+			return s.And(s.Cmd("run", "2"), s.Cmd("run", "b"))
+		}, "2", 2},
+		{"or stops", func(s *Session) *Cmd {
+			return s.Or(s.Cmd("run", "a"), s.Cmd("run", "b"))
+		}, "a", 0},
+		{"or next", func(s *Session) *Cmd {
+			return s.Or(s.Cmd("run", "1"), s.Cmd("run", "b"), s.Cmd("run", "c"))
+		}, "1|b", 0},
+		{"or all fail", func(s *Session) *Cmd {
+			return s.Or(s.Cmd("run", "1"), s.Cmd("run", "2"), s.Cmd("run", "4"))
+		}, "1|2|4", 4},
+		{"external status", func(s *Session) *Cmd {
+			return s.And(s.Cmd("run", "a"), s.Cmd("sh", "-c", "exit 5"), s.Cmd("run", "c"))
+		}, "a", 5},
+		{"or of ands", func(s *Session) *Cmd {
+			return s.Or(
+				s.And(s.Cmd("run", "a"), s.Cmd("run", "1")),
+				s.And(s.Cmd("run", "b"), s.Cmd("run", "c")),
+			)
+		}, "a|1|b|c", 0},
+		{"and of ors", func(s *Session) *Cmd {
+			return s.And(
+				s.Or(s.Cmd("run", "1"), s.Cmd("run", "a")),
+				s.Or(s.Cmd("run", "2"), s.Cmd("run", "3")),
+				s.Cmd("run", "c"),
+			)
+		}, "1|a|2|3", 3},
+		{"pipe in and", func(s *Session) *Cmd {
+			return s.And(
+				s.Pipe(s.Cmd("echo", "x"), s.Cmd("sh", "-c", "read x; exit 6")),
+				s.Cmd("run", "b"),
+			)
+		}, "", 6},
+		{"pipe in or", func(s *Session) *Cmd {
+			return s.Or(
+				s.Pipe(s.Cmd("echo", "x"), s.Cmd("sh", "-c", "exit 6")),
+				s.Cmd("run", "b"),
+			)
+		}, "b", 0},
+		{"empty", func(s *Session) *Cmd {
+			return s.And()
+		}, "", 0},
+	}
+	for _, tt := range tests {
+		var ran []string
+		s := New().Funcs(runFuncs(&ran))
+		err := tt.cmd(s).Run()
+		if got := exitCode(err); got != tt.code {
+			t.Errorf("%s: got status %d (%v), want %d", tt.name, got, err, tt.code)
+		}
+		if got := strings.Join(ran, "|"); got != tt.ran {
+			t.Errorf("%s: ran %q, want %q", tt.name, got, tt.ran)
+		}
+		if (s.Error() != nil) != (tt.code != 0) {
+			t.Errorf("%s: session error %v", tt.name, s.Error())
+		}
+	}
+}
+
+func TestCmdPipe(t *testing.T) {
+	s := New()
+	var out strings.Builder
+	err := s.Pipe(
+		s.Cmd("echo", "b a $HOME *"),
+		s.Cmd("tr", " ", "\n"),
+		s.Cmd("sort"),
+	).Stdout(&out).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "$HOME\n*\na\nb\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+	if s.Stdout != nil {
+		t.Errorf("session stdout changed to %v", s.Stdout)
+	}
+
+	// the status is from the last command that failed
+	err = New().Pipe(s.Cmd("sh", "-c", "exit 2"), s.Cmd("true")).Run()
+	if got := exitCode(err); got != 2 {
+		t.Errorf("early failure: got status %d (%v), want 2", got, err)
+	}
+}
+
+func TestCmdString(t *testing.T) {
+	s := New()
+	tests := []struct {
+		cmd  *Cmd
+		want string
+	}{
+		{s.Cmd("cp", "a b", "$x", "c"), "cp 'a b' '$x' c"},
+		{s.Cmd("echo", "it's", ""), `echo 'it'\''s' ''`},
+		{s.Pipe(s.Cmd("a"), s.Cmd("b")), "a | b"},
+		{s.And(s.Cmd("a"), s.Or(s.Cmd("b"), s.Cmd("c"))), "a && { b || c; }"},
+	}
+	for _, tt := range tests {
+		if got := tt.cmd.String(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
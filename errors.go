@@ -0,0 +1,84 @@
+package gsh
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// ScriptError is returned when a script stops because a command
+// failed, or could not be parsed.  The cause is available with
+// errors.Is and errors.As, so an *exec.ExitError can still be
+// checked.
+type ScriptError struct {
+	Cmd      string   // text of the command, "" for a parse error or signal
+	Args     []string // the command after expansion, if it got that far
+	Line     int      // line of the script the command starts on
+	ExitCode int      // exit status of the command
+	Stderr   string   // the end of what the command wrote to stderr
+	Err      error    // the cause
+}
+
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message())
+}
+
+// Message is the error without the line number, for printing as
+// "file:line: message".
+func (e *ScriptError) Message() string {
+	msg := e.Err.Error()
+	if e.Cmd != "" {
+		msg = e.Cmd + ": " + msg
+	}
+	if line := lastLine(e.Stderr); line != "" {
+		msg += ": " + line
+	}
+	return msg
+}
+
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
+
+// stderrTail is how much of stderr is kept for a ScriptError
+const stderrTail = 1024
+
+// tailWriter passes writes through and keeps the last bytes
+type tailWriter struct {
+	w   io.Writer
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	t.buf = append(t.buf, p...)
+	if n := len(t.buf); n > stderrTail {
+		t.buf = append(t.buf[:0], t.buf[n-stderrTail:]...)
+	}
+	t.mu.Unlock()
+	if t.w == nil {
+		return len(p), nil
+	}
+	return t.w.Write(p)
+}
+
+func (t *tailWriter) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.buf)
+}
+
+// keepStderr wraps stderr to keep its tail for a ScriptError.  When
+// stderr is a file, such as a terminal, it is left alone so commands
+// still see the file, and nil is returned.
+func (s *Session) keepStderr() (*tailWriter, func()) {
+	if _, ok := s.Stderr.(*os.File); ok {
+		return nil, func() {}
+	}
+	old := s.Stderr
+	t := &tailWriter{w: old}
+	s.Stderr = t
+	return t, func() { s.Stderr = old }
+}
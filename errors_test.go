@@ -0,0 +1,104 @@
+package gsh
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestScriptError(t *testing.T) {
+	tests := []struct {
+		script string
+		line   int
+		cmd    string
+		args   string
+		code   int
+		msg    string
+	}{
+		{"true\n\nsh -c 'exit 3'\ntrue", 3, "sh -c 'exit 3'", "sh|-c|exit 3", 3,
+			"line 3: sh -c 'exit 3': exit status 3"},
+		{"x=a\ny=b\n# comment\nsh -c 'echo $1 >&2; exit 1' sh \"$x$y\"", 4, "sh -c 'echo $1 >&2; exit 1' sh \"$x$y\"", "sh|-c|echo $1 >&2; exit 1|sh|ab", 1,
+			"line 4: sh -c 'echo $1 >&2; exit 1' sh \"$x$y\": exit status 1: ab"},
+		{"echo a \\\n  b\nfalse", 3, "false", "false", 1,
+			"line 3: false: exit status 1"},
+		{"f() {\n  true\n  false\n}\n\nf", 3, "false", "false", 1,
+			"line 3: false: exit status 1"},
+		{"true\necho 'a", 2, "", "", 2,
+			"line 2: unterminated ' quote"},
+		{"true\nnosuchcommand a", 2, "nosuchcommand a", "nosuchcommand|a", 1,
+			"line 2: nosuchcommand a: exec: \"nosuchcommand\": executable file not found in $PATH"},
+	}
+	for _, tt := range tests {
+		s := New()
+		s.Env["PATH"] = "/bin:/usr/bin"
+		s.Stdout = &strings.Builder{}
+		s.Stderr = &strings.Builder{}
+		err := s.Exec(tt.script)
+		var serr *ScriptError
+		if !errors.As(err, &serr) {
+			t.Errorf("%q: got %T %v, want a *ScriptError", tt.script, err, err)
+			continue
+		}
+		if serr.Line != tt.line || serr.Cmd != tt.cmd || serr.ExitCode != tt.code {
+			t.Errorf("%q: got line %d cmd %q status %d, want %d %q %d", tt.script,
+				serr.Line, serr.Cmd, serr.ExitCode, tt.line, tt.cmd, tt.code)
+		}
+		if args := strings.Join(serr.Args, "|"); args != tt.args {
+			t.Errorf("%q: got args %q, want %q", tt.script, args, tt.args)
+		}
+		if err.Error() != tt.msg {
+			t.Errorf("%q: got %q, want %q", tt.script, err.Error(), tt.msg)
+		}
+		if errors.Unwrap(err) != serr.Err || serr.Err == nil {
+			t.Errorf("%q: Unwrap is %v, want %v", tt.script, errors.Unwrap(err), serr.Err)
+		}
+	}
+}
+
+func TestScriptErrorCause(t *testing.T) {
+	s := New()
+	err := s.Exec("sh -c 'exit 4'")
+	var exit *exec.ExitError
+	if !errors.As(err, &exit) || exit.ExitCode() != 4 {
+		t.Errorf("got %v, want an *exec.ExitError", err)
+	}
+
+	s = New()
+	err = s.Exec("nosuchcommand")
+	if !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("got %v, want exec.ErrNotFound", err)
+	}
+
+	// a policy error is kept as the cause
+	s = New()
+	if err := s.Restrict(Policy{Commands: []string{"echo"}}); err != nil {
+		t.Fatal(err)
+	}
+	err = s.Exec("ls")
+	var perr *PolicyError
+	var serr *ScriptError
+	if !errors.As(err, &perr) || !errors.As(err, &serr) || serr.Line != 1 {
+		t.Errorf("got %v, want a *PolicyError in a *ScriptError", err)
+	}
+}
+
+func TestScriptErrorStderr(t *testing.T) {
+	s := New()
+	var stderr strings.Builder
+	s.Stderr = &stderr
+	err := s.Exec("sh -c 'head -c 5000 /dev/zero | tr \"\\0\" a >&2; echo >&2; echo last line >&2; exit 1'")
+	var serr *ScriptError
+	if !errors.As(err, &serr) {
+		t.Fatalf("got %v", err)
+	}
+	if len(serr.Stderr) > stderrTail || !strings.HasSuffix(serr.Stderr, "last line\n") {
+		t.Errorf("stderr tail is %d bytes, ends %q", len(serr.Stderr), serr.Stderr[max(0, len(serr.Stderr)-20):])
+	}
+	if stderr.Len() != 5011 {
+		t.Errorf("session stderr got %d bytes, want 5011", stderr.Len())
+	}
+	if !strings.HasSuffix(err.Error(), ": last line") {
+		t.Errorf("got %q", err.Error())
+	}
+}
@@ -0,0 +1,447 @@
+package gsh
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// expand replaces shell variables in str.  If wrap is not nil, it is
+// applied to the value of each expansion.
+//
+// Besides $VAR and ${VAR} the POSIX and common bash forms are
+// supported:
+//
+//	${VAR:-word}  ${VAR-word}   use word if unset (or empty)
+//	${VAR:=word}  ${VAR=word}   assign word if unset (or empty)
+//	${VAR:?msg}   ${VAR?msg}    error if unset (or empty)
+//	${VAR:+word}  ${VAR+word}   use word if set (and not empty)
+//	${#VAR}                     length
+//	${VAR#pat}    ${VAR##pat}   remove shortest or longest prefix
+//	${VAR%pat}    ${VAR%%pat}   remove shortest or longest suffix
+//	${VAR/p/s}    ${VAR//p/s}   replace first or all matches
+//	${VAR/#p/s}   ${VAR/%p/s}   replace a prefix or suffix
+//	${VAR:off}    ${VAR:off:n}  substring
+//	${VAR^^}  ${VAR^}  ${VAR,,}  ${VAR,}  change case
+func (s *Session) expand(str string, wrap func(string) string) (string, error) {
+	if strings.IndexByte(str, '$') == -1 {
+		return str, nil
+	}
+	var buf strings.Builder
+	for i := 0; i < len(str); i++ {
+		if str[i] != '$' {
+			buf.WriteByte(str[i])
+			continue
+		}
+		expr, end, err := scanParam(str, i)
+		if err != nil {
+			return "", err
+		}
+		if end == i+1 {
+			buf.WriteByte('$')
+			continue
+		}
+		val, err := s.expandParam(expr)
+		if err != nil {
+			return "", err
+		}
+		if wrap != nil {
+			val = wrap(val)
+		}
+		buf.WriteString(val)
+		i = end - 1
+	}
+	return buf.String(), nil
+}
+
+// scanParam finds the parameter expression of the "$" at str[i].
+// It returns the expression and the index just after it.  If the
+// "$" does not start an expansion the end is i+1.  For arithmetic
+// the expression is "((...))".
+func scanParam(str string, i int) (string, int, error) {
+	if i+1 == len(str) {
+		return "", i + 1, nil
+	}
+	switch c := str[i+1]; {
+	case c == '(' && strings.HasPrefix(str[i+1:], "(("):
+		end := matchArith(str, i)
+		if end == -1 {
+			return "", 0, fmt.Errorf("%s: missing ))", str[i:])
+		}
+		return str[i+1 : end], end, nil
+	case c == '{':
+		end := matchBrace(str, i+1)
+		if end == -1 {
+			return "", 0, fmt.Errorf("%s: missing }", str[i:])
+		}
+		return str[i+2 : end], end + 1, nil
+	case isSpecialParam(c) || isDigit(c):
+		return str[i+1 : i+2], i + 2, nil
+	case isNameStart(c):
+		j := i + 2
+		for j < len(str) && isNameChar(str[j]) {
+			j++
+		}
+		return str[i+1 : j], j, nil
+	}
+	return "", i + 1, nil
+}
+
+// matchBrace returns the index of the "}" closing the "{" at start,
+// allowing for nested "${...}".
+func matchBrace(str string, start int) int {
+	depth := 0
+	for i := start; i < len(str); i++ {
+		switch str[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || isDigit(c)
+}
+
+func isSpecialParam(c byte) bool {
+	return strings.IndexByte("@*#?!$-", c) != -1
+}
+
+// paramName splits the parameter name from the operator that follows
+func paramName(expr string) (string, string) {
+	if expr == "" {
+		return "", ""
+	}
+	switch c := expr[0]; {
+	case isSpecialParam(c):
+		return expr[:1], expr[1:]
+	case isDigit(c):
+		i := 1
+		for i < len(expr) && isDigit(expr[i]) {
+			i++
+		}
+		return expr[:i], expr[i:]
+	case isNameStart(c):
+		i := 1
+		for i < len(expr) && isNameChar(expr[i]) {
+			i++
+		}
+		return expr[:i], expr[i:]
+	}
+	return "", expr
+}
+
+// expandParam expands the inside of ${...}, or $((...)) arithmetic
+func (s *Session) expandParam(expr string) (string, error) {
+	if strings.HasPrefix(expr, "((") {
+		inner, err := s.expand(expr[2:len(expr)-2], nil)
+		if err != nil {
+			return "", err
+		}
+		n, err := s.arith(inner)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(n, 10), nil
+	}
+	// ${#VAR} is the length, but ${#} is the number of args
+	if len(expr) > 1 && expr[0] == '#' {
+		name, rest := paramName(expr[1:])
+		if name == "" || rest != "" {
+			return "", fmt.Errorf("${%s}: bad substitution", expr)
+		}
+		val, err := s.param(name)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(utf8.RuneCountInString(val)), nil
+	}
+
+	name, op := paramName(expr)
+	if name == "" {
+		return "", fmt.Errorf("${%s}: bad substitution", expr)
+	}
+	if op == "" {
+		return s.param(name)
+	}
+
+	val, set := s.lookupVar(name)
+
+	// ${VAR:-word} and friends.  With the colon an empty
+	// value is treated the same as unset.
+	colon := op[0] == ':'
+	test := op
+	if colon {
+		test = op[1:]
+	}
+	if test != "" && strings.IndexByte("-=?+", test[0]) != -1 {
+		word := test[1:]
+		isSet := set && (!colon || val != "")
+		switch test[0] {
+		case '-':
+			if isSet {
+				return val, nil
+			}
+			return s.expandString(word)
+		case '=':
+			if isSet {
+				return val, nil
+			}
+			if !isNameStart(name[0]) {
+				return "", fmt.Errorf("${%s}: can not assign this way", expr)
+			}
+			word, err := s.expandString(word)
+			if err != nil {
+				return "", err
+			}
+			if err := s.SetVar(name, word); err != nil {
+				return "", err
+			}
+			return word, nil
+		case '?':
+			if isSet {
+				return val, nil
+			}
+			msg, err := s.expandString(word)
+			if err != nil {
+				return "", err
+			}
+			if msg == "" {
+				msg = "parameter null or not set"
+			}
+			return "", fmt.Errorf("%s: %s", name, msg)
+		case '+':
+			if isSet {
+				return s.expandString(word)
+			}
+			return "", nil
+		}
+	}
+
+	if !set && s.opts.nounset {
+		return "", fmt.Errorf("%s: unbound variable", name)
+	}
+
+	switch {
+	case colon:
+		return substring(expr, val, op[1:])
+	case op[0] == '#' || op[0] == '%':
+		longest := len(op) > 1 && op[1] == op[0]
+		pat := op[1:]
+		if longest {
+			pat = op[2:]
+		}
+		pat, err := s.expandString(pat)
+		if err != nil {
+			return "", err
+		}
+		return trimPattern(val, pat, op[0] == '#', longest)
+	case strings.HasPrefix(op, "/"):
+		return s.replacePattern(expr, val, op[1:])
+	case op == "^^":
+		return strings.ToUpper(val), nil
+	case op == ",,":
+		return strings.ToLower(val), nil
+	case op == "^", op == ",":
+		r, size := utf8.DecodeRuneInString(val)
+		if size == 0 {
+			return val, nil
+		}
+		if op == "^" {
+			r = unicode.ToUpper(r)
+		} else {
+			r = unicode.ToLower(r)
+		}
+		return string(r) + val[size:], nil
+	}
+	return "", fmt.Errorf("${%s}: bad substitution", expr)
+}
+
+// param returns the value of a parameter, or an error if it is
+// unset and the nounset option is on.
+func (s *Session) param(name string) (string, error) {
+	val, ok := s.lookupVar(name)
+	if !ok && s.opts.nounset {
+		return "", fmt.Errorf("%s: unbound variable", name)
+	}
+	return val, nil
+}
+
+// substring implements ${VAR:offset} and ${VAR:offset:length}
+func substring(expr string, val string, spec string) (string, error) {
+	runes := []rune(val)
+	offStr, lenStr, hasLen := strings.Cut(spec, ":")
+	off, err := strconv.Atoi(strings.TrimSpace(offStr))
+	if err != nil {
+		return "", fmt.Errorf("${%s}: bad offset", expr)
+	}
+	if off < 0 {
+		off += len(runes)
+	}
+	if off < 0 || off > len(runes) {
+		return "", nil
+	}
+	end := len(runes)
+	if hasLen {
+		n, err := strconv.Atoi(strings.TrimSpace(lenStr))
+		if err != nil {
+			return "", fmt.Errorf("${%s}: bad length", expr)
+		}
+		if n < 0 {
+			end += n
+		} else if off+n < end {
+			end = off + n
+		}
+		if end < off {
+			return "", fmt.Errorf("${%s}: substring expression < 0", expr)
+		}
+	}
+	return string(runes[off:end]), nil
+}
+
+// trimPattern removes the shortest or longest prefix or suffix of
+// val matching a shell pattern.
+func trimPattern(val string, pat string, prefix bool, longest bool) (string, error) {
+	re, err := patternRegexp(pat, true)
+	if err != nil {
+		return "", err
+	}
+	n := len(val)
+	for k := 0; k <= n; k++ {
+		i := k
+		if longest {
+			i = n - k
+		}
+		if prefix {
+			if re.MatchString(val[:i]) {
+				return val[i:], nil
+			}
+		} else if re.MatchString(val[n-i:]) {
+			return val[:n-i], nil
+		}
+	}
+	return val, nil
+}
+
+// replacePattern implements ${VAR/pat/repl} and its variations
+func (s *Session) replacePattern(expr string, val string, spec string) (string, error) {
+	mode := byte(0)
+	if spec != "" && strings.IndexByte("/#%", spec[0]) != -1 {
+		mode, spec = spec[0], spec[1:]
+	}
+	pat, repl := spec, ""
+	for i := 0; i < len(spec); i++ {
+		if spec[i] == '\\' {
+			i++
+		} else if spec[i] == '/' {
+			pat, repl = spec[:i], spec[i+1:]
+			break
+		}
+	}
+	pat, err := s.expandString(pat)
+	if err != nil {
+		return "", err
+	}
+	repl, err = s.expandString(repl)
+	if err != nil {
+		return "", err
+	}
+	if pat == "" {
+		return val, nil
+	}
+	if mode == '%' {
+		// longest matching suffix
+		re, err := patternRegexp(pat, true)
+		if err != nil {
+			return "", err
+		}
+		for i := 0; i <= len(val); i++ {
+			if re.MatchString(val[i:]) {
+				return val[:i] + repl, nil
+			}
+		}
+		return val, nil
+	}
+	re, err := patternRegexp(pat, false)
+	if err != nil {
+		return "", err
+	}
+	re.Longest()
+	switch mode {
+	case '#':
+		if loc := re.FindStringIndex(val); loc != nil && loc[0] == 0 {
+			return repl + val[loc[1]:], nil
+		}
+		return val, nil
+	case '/':
+		return re.ReplaceAllLiteralString(val, repl), nil
+	}
+	if loc := re.FindStringIndex(val); loc != nil {
+		return val[:loc[0]] + repl + val[loc[1]:], nil
+	}
+	return val, nil
+}
+
+// patternRegexp converts a shell pattern to a regular expression.
+// "*" and "?" match any character, including "/".
+func patternRegexp(pat string, anchored bool) (*regexp.Regexp, error) {
+	var buf strings.Builder
+	if anchored {
+		buf.WriteString("^(?s:")
+	} else {
+		buf.WriteString("(?s:")
+	}
+	for i := 0; i < len(pat); i++ {
+		switch c := pat[i]; c {
+		case '*':
+			buf.WriteString(".*")
+		case '?':
+			buf.WriteString(".")
+		case '\\':
+			if i+1 < len(pat) {
+				i++
+				buf.WriteString(regexp.QuoteMeta(pat[i : i+1]))
+			} else {
+				buf.WriteString(`\\`)
+			}
+		case '[':
+			end := strings.IndexByte(pat[i+1:], ']')
+			if end == -1 {
+				buf.WriteString(`\[`)
+				continue
+			}
+			class := pat[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			buf.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	buf.WriteString(")")
+	if anchored {
+		buf.WriteString("$")
+	}
+	return regexp.Compile(buf.String())
+}
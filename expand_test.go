@@ -0,0 +1,47 @@
+package gsh
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParamExpansion(t *testing.T) {
+	tests := []struct {
+		script string
+		want   string
+		err    bool
+	}{
+		{"x=abc\necho $x ${x} ${x}d", "abc abc abcd", false},
+		{"x=abc\necho ${x:-d} ${y:-d} ${#x}", "abc d 3", false},
+		{"x=\necho ${x:-d} ${x-d}.", "d .", false},
+		{"echo ${y:=set} $y", "set set", false},
+		{"x=a\necho ${x:+alt} ${y:+alt}.", "alt .", false},
+		{"x=\necho ${x+alt} ${x:+alt}.", "alt .", false},
+		{"echo ${y:?not set}", "", true},
+		{"x=abcdef\necho ${x:1:3} ${x: -2} ${x:4}", "bcd ef ef", false},
+		{"f=a.tar.gz\necho ${f%.*} ${f%%.*} ${f#*.} ${f##*.}", "a.tar a tar.gz gz", false},
+		{"x=aaa\necho ${x/a/b} ${x//a/b}", "baa bbb", false},
+		{"x=abcab\necho ${x/#ab/X} ${x/%ab/X}", "Xcab abcX", false},
+		{"x=hello\necho ${x^} ${x^^}", "Hello HELLO", false},
+		{"x=HELLO\necho ${x,} ${x,,}", "hELLO hello", false},
+		{"echo $unset.", ".", false},
+		{"set -u\necho $unset", "", true},
+		{"set -u\necho ${unset:-ok}", "ok", false},
+		{"echo ${x", "", true},
+		{"echo a$", "a$", false},
+	}
+	for _, tt := range tests {
+		s := New()
+		delete(s.Env, "unset")
+		var out strings.Builder
+		s.Stdout = &out
+		err := s.Exec(tt.script)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: error %v", tt.script, err)
+			continue
+		}
+		if out.String() != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.script, out.String(), tt.want)
+		}
+	}
+}
@@ -3,55 +3,63 @@ package gsh
 import (
 	"bytes"
 	"fmt"
-	"os"
-	"os/exec"
 	"path"
 	"text/template"
 )
 
-// BUG: does not use local session path
-
-func commandExists(path string) bool {
-	_, err := exec.LookPath(path)
+func (s *Session) commandExists(name string) bool {
+	_, err := s.lookPath(name)
 	return err == nil
 }
 
-func fileIsDirectory(path string) bool {
-	info, err := os.Stat(path)
+func fileIsDirectory(fsys FS, path string) bool {
+	info, err := fsys.Stat(path)
 	return err == nil && info.IsDir()
 }
 
-func fileIsRegular(fname string) bool {
-	info, err := os.Stat(fname)
+func fileIsRegular(fsys FS, fname string) bool {
+	info, err := fsys.Stat(fname)
 	return err == nil && info.Mode().IsRegular()
 }
 
-func fileExists(fname string) bool {
-	_, err := os.Stat(fname)
+func fileExists(fsys FS, fname string) bool {
+	_, err := fsys.Stat(fname)
 	return err == nil
 }
 
 var fmap = template.FuncMap{
-	"fileIsRegular":   fileIsRegular,
-	"fileIsDirectory": fileIsDirectory,
-	"fileExists":      fileExists,
-	"commandExists":   commandExists,
-	"basename":        path.Base,
+	"basename": path.Base,
 }
 
+// sessionFuncs are the file tests, using the session working directory
+func (s *Session) sessionFuncs() template.FuncMap {
+	return template.FuncMap{
+		"commandExists":    s.commandExists,
+		"fileIsRegular":    func(f string) bool { return fileIsRegular(s.FS, s.Abs(f)) },
+		"fileIsDirectory":  func(f string) bool { return fileIsDirectory(s.FS, s.Abs(f)) },
+		"fileExists":       func(f string) bool { return fileExists(s.FS, s.Abs(f)) },
+		"fileLastModified": func(f string) (string, error) { return fileLastModified(s.FS, s.Abs(f)) },
+		"gitLastModified":  s.gitLastModified,
+		"httpLastModified": func(u string) (string, error) { return httpLastModified(s.httpClient(), u) },
+	}
+}
 
 func (s *Session) Test(str string) bool {
 	// special replacement of environment variables.
 	// in regular case we just ${foo} --> bar
 	// in this case they are quoted ${foo} --> "bar"
 	//  since they need to be golang proper values
-	str = os.Expand(str, func(key string) string {
-		return fmt.Sprintf("%q", s.Env[key])
+	str, err := s.expand(str, func(val string) string {
+		return fmt.Sprintf("%q", val)
 	})
+	if err != nil {
+		s.SetError(err)
+		return false
+	}
 
-	t := template.New("gsh.test").Funcs(fmap)
+	t := template.New("gsh.test").Funcs(fmap).Funcs(s.sessionFuncs())
 	src := fmt.Sprintf("{{ if (%s) }}1{{ else }}0{{ end }}", str)
-	t, err := t.Parse(src)
+	t, err = t.Parse(src)
 	if err != nil {
 		s.SetError(fmt.Errorf("Unable to parse %q: %s", src, err))
 		return false
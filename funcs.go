@@ -0,0 +1,179 @@
+package gsh
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxCallDepth limits recursion of script functions
+const maxCallDepth = 1000
+
+// Functions defined in a script with "name() { ... }" are stored in
+// the same FuncMap as Go builtins, so there is one namespace for
+// both.  When a command runs, aliases are expanded first, then the
+// FuncMap is checked, and only then is an external command run.
+// A script function replaces a Go builtin of the same name, and a
+// later definition replaces an earlier one.  "unset -f" removes a
+// script function and brings back the builtin it replaced.
+
+// frame holds the state of one call to a script function
+type frame struct {
+	args  []string            // positional parameters, $1 ...
+	saved map[string]savedVar // variables hidden by "local"
+}
+
+// savedVar is a variable as it was before "local"
+type savedVar struct {
+	val      string
+	set      bool
+	exported bool
+}
+
+// returnStatus is used by "return" to unwind a function call
+type returnStatus int
+
+func (r returnStatus) Error() string {
+	return fmt.Sprintf("return %d outside of function", int(r))
+}
+
+// ExitStatus is the error returned when a script function
+// returns a non-zero status.
+type ExitStatus int
+
+func (e ExitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+// defineFunc adds a script function to the FuncMap
+func (s *Session) defineFunc(name string, body []*node) {
+	if _, ok := s.funcs[name]; !ok {
+		s.funcs[name] = s.fmap[name]
+	}
+	s.fmap[name] = func(s *Session, args []string) error {
+		return s.callFunc(body, args)
+	}
+}
+
+// callFunc runs the body of a script function with args as
+// the positional parameters
+func (s *Session) callFunc(body []*node, args []string) error {
+	if len(s.frames) >= maxCallDepth {
+		return fmt.Errorf("%s: maximum function nesting level exceeded (%d)", args[0], maxCallDepth)
+	}
+	f := &frame{args: args[1:], saved: make(map[string]savedVar)}
+	s.frames = append(s.frames, f)
+	defer func() {
+		s.frames = s.frames[:len(s.frames)-1]
+		for k, v := range f.saved {
+			if v.set {
+				s.Env[k] = v.val
+			} else {
+				delete(s.Env, k)
+			}
+			if v.exported {
+				s.exported[k] = true
+			} else {
+				delete(s.exported, k)
+			}
+		}
+	}()
+
+	err := s.runNodes(body)
+	if r, ok := err.(returnStatus); ok {
+		if r == 0 {
+			return nil
+		}
+		return ExitStatus(r)
+	}
+	return err
+}
+
+// positional returns the arguments of the current function call
+func (s *Session) positional() []string {
+	if len(s.frames) == 0 {
+		return nil
+	}
+	return s.frames[len(s.frames)-1].args
+}
+
+// isPositional reports if key is "1", "#", "@" or similar
+func isPositional(key string) bool {
+	switch key {
+	case "#", "@", "*":
+		return true
+	}
+	n, err := strconv.Atoi(key)
+	return err == nil && n > 0
+}
+
+// lookupPositional returns "$1", "$#", "$@" and friends, and if
+// they are set
+func (s *Session) lookupPositional(key string) (string, bool) {
+	args := s.positional()
+	switch key {
+	case "#":
+		return strconv.Itoa(len(args)), true
+	case "@", "*":
+		return strings.Join(args, " "), true
+	}
+	n, _ := strconv.Atoi(key)
+	if n > len(args) {
+		return "", false
+	}
+	return args[n-1], true
+}
+
+// Local makes variables local to the current function.  The
+// previous values, and if they were exported, are restored when the
+// function returns.  A readonly variable cannot be made local.
+//
+//	local NAME[=value] ...
+func Local(s *Session, cli []string) error {
+	name, args := cli[0], cli[1:]
+	if len(s.frames) == 0 {
+		return fmt.Errorf("%s: can only be used in a function", name)
+	}
+	f := s.frames[len(s.frames)-1]
+	for _, kv := range args {
+		key, val, hasVal := strings.Cut(kv, "=")
+		if key == "" || !isAssignment(key+"=") {
+			return fmt.Errorf("%s: %q: not a valid identifier", name, kv)
+		}
+		if s.readonly[key] {
+			return fmt.Errorf("%s: %s: readonly variable", name, key)
+		}
+		if _, ok := f.saved[key]; !ok {
+			old, set := s.Env[key]
+			f.saved[key] = savedVar{val: old, set: set, exported: s.exported[key]}
+		}
+		if hasVal {
+			if err := s.SetVar(key, val); err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+		} else {
+			delete(s.Env, key)
+		}
+	}
+	return nil
+}
+
+// Return returns from a script function with an optional status
+func Return(s *Session, cli []string) error {
+	name, args := cli[0], cli[1:]
+	if len(s.frames) == 0 {
+		return fmt.Errorf("%s: can only be used in a function", name)
+	}
+	switch len(args) {
+	case 0:
+		return returnStatus(0)
+	case 1:
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("%s: %s: numeric argument required", name, args[0])
+		}
+		return returnStatus(n)
+	default:
+		return fmt.Errorf("%s: too many arguments", name)
+	}
+}
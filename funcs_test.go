@@ -0,0 +1,95 @@
+package gsh
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFuncs(t *testing.T) {
+	tests := []struct {
+		script string
+		want   string
+		code   int // exit status, -1 for an error that is not one
+	}{
+		{"greet() { echo hi $1; }\ngreet bob", "hi bob", 0},
+		{"f() { echo $# $@; }\nf a 'b c'", "2 a b c", 0},
+		{"f() { echo ${2:-none}; }\nf a", "none", 0},
+		{"f() {\n  echo a\n  return\n  echo b\n}\nf", "a", 0},
+		{"f() { return 3; }\nf", "", 3},
+		{"f() { return 0; }\nf\necho ok", "ok", 0},
+		{"f() { false; echo b; }\nf", "", 1},
+		{"f() { echo 1; }\nf() { echo 2; }\nf", "2", 0},
+		{"echo() { which sh; }\necho x", "/bin/sh", 0},
+		{"f() { g; }\ng() { echo g; }\nf", "g", 0},
+		{"f() { f; }\nf", "", -1},
+		{"return", "", -1},
+		{"f() { return x; }\nf", "", -1},
+	}
+	for _, tt := range tests {
+		s := New()
+		var out strings.Builder
+		s.Stdout = &out
+		s.Env["PATH"] = "/bin"
+		err := s.Exec(tt.script)
+		var serr *ScriptError
+		switch {
+		case tt.code == 0 && err != nil:
+			t.Errorf("%q: %v", tt.script, err)
+		case tt.code == -1 && err == nil:
+			t.Errorf("%q: no error", tt.script)
+		case tt.code > 0 && (!errors.As(err, &serr) || serr.ExitCode != tt.code):
+			t.Errorf("%q: got %v, want exit status %d", tt.script, err, tt.code)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.script, out.String(), tt.want)
+		}
+	}
+}
+
+func TestLocal(t *testing.T) {
+	tests := []struct {
+		script string
+		want   string
+		err    bool
+	}{
+		{"x=1\nf() { local x=2; echo $x; }\nf\necho $x", "21", false},
+		{"f() { local x=2; }\nf\necho \"[$x]\"", "[]", false},
+		{"x=1\nf() { local x; echo \"[$x]\"; }\nf\necho $x", "[]1", false},
+		{"x=1\nf() { local x=2; g; }\ng() { echo $x; }\nf\necho $x", "21", false},
+		{"x=1\nf() { local x=2; local x=3; }\nf\necho $x", "1", false},
+		{"x=1\nf() { local x=2; unset x; }\nf\necho $x", "1", false},
+		{"export x=1\nf() { local x=2; sh -c 'echo $x'; }\nf\nsh -c 'echo $x'", "2\n1\n", false},
+		{"export x=1\nf() { local x; export -n x; }\nf\nsh -c 'echo $x'", "1\n", false},
+		{"x=1\nf() { local x=2; export x; }\nf\nsh -c 'echo \"[$x]\"'", "[]\n", false},
+		{"f() { local y=2; export y; }\nf\nsh -c 'echo \"[$y]\"'", "[]\n", false},
+		{"local x=1", "", true},
+		{"f() { local 1abc=x; }\nf", "", true},
+		{"f() { local a-b; }\nf", "", true},
+		{"f() { local =x; }\nf", "", true},
+		{"readonly X=1\nf() { local X; }\nf", "", true},
+		{"readonly X=1\nf() { local X=2; }\nf", "", true},
+	}
+	for _, tt := range tests {
+		s := New()
+		s.Env = map[string]string{"PATH": "/bin"}
+		s.exported = map[string]bool{"PATH": true}
+		var out strings.Builder
+		s.Stdout = &out
+		err := s.Exec(tt.script)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: error %v", tt.script, err)
+			continue
+		}
+		if out.String() != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.script, out.String(), tt.want)
+		}
+	}
+
+	// a failed local leaves the readonly variable alone
+	s := New()
+	s.Exec("readonly X=1", "f() { local X; }", "f")
+	if s.Env["X"] != "1" {
+		t.Errorf("readonly X is %q after local", s.Env["X"])
+	}
+}
@@ -0,0 +1,411 @@
+package gsh
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// globChars are escaped with a backslash when quoted in a pattern
+const globChars = `*?[\`
+
+// Unquoted words with "*", "?" or "[...]" are matched against the
+// session working directory, like the shell does.  Names starting
+// with "." are only matched if the pattern starts with ".".  A word
+// matching nothing is left as is, unless one of these options is on:
+//
+//	nullglob      the word is removed
+//	failglob      the command fails
+//	globstar      "**" matches any number of directories
+//	noglob (-f)   no globbing at all
+//
+// Before anything else "a{b,c}d" is expanded to "abd acd" and
+// "{1..3}" to "1 2 3", unless braceexpand (-B) is off.
+
+// glob matches a pattern relative to the session working directory.
+// Matches are returned relative if the pattern was relative.
+func (s *Session) glob(pattern string) ([]string, error) {
+	var matches []string
+	dir, rest := "", pattern
+	if filepath.IsAbs(pattern) {
+		dir, rest = "/", strings.TrimLeft(pattern, "/")
+	}
+	err := s.globDir(dir, strings.Split(rest, "/"), &matches)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// globDir adds the matches of the pattern segments within dir
+func (s *Session) globDir(dir string, segs []string, matches *[]string) error {
+	if len(segs) == 0 {
+		*matches = append(*matches, dir)
+		return nil
+	}
+	seg, rest := segs[0], segs[1:]
+	switch {
+	case seg == "":
+		// "a//b" or a trailing "/"
+		if len(rest) == 0 {
+			*matches = append(*matches, dir+"/")
+			return nil
+		}
+		return s.globDir(dir, rest, matches)
+	case !hasGlobChars(seg) || !validPattern(seg):
+		// an invalid pattern is a plain name, as in sh
+		name := joinGlob(dir, unescapeGlob(seg))
+		if _, err := s.FS.Lstat(s.Abs(name)); err != nil {
+			return nil
+		}
+		return s.globDir(name, rest, matches)
+	case seg == "**" && s.opts.globstar:
+		if len(rest) == 0 {
+			return s.globAll(dir, matches, false)
+		}
+		if err := s.globDir(dir, rest, matches); err != nil {
+			return err
+		}
+		var dirs []string
+		if err := s.globAll(dir, &dirs, true); err != nil {
+			return err
+		}
+		for _, d := range dirs {
+			if err := s.globDir(d, rest, matches); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	entries, err := s.FS.ReadDir(s.Abs(dirOrDot(dir)))
+	if err != nil {
+		return nil
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") && !strings.HasPrefix(seg, ".") {
+			continue
+		}
+		if ok, _ := filepath.Match(seg, e.Name()); !ok {
+			continue
+		}
+		name := joinGlob(dir, e.Name())
+		if len(rest) > 0 && !fileIsDirectory(s.FS, s.Abs(name)) {
+			continue
+		}
+		if err := s.globDir(name, rest, matches); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// globAll adds everything below dir that is not hidden, or only
+// directories if dirsOnly is set.
+func (s *Session) globAll(dir string, matches *[]string, dirsOnly bool) error {
+	entries, err := s.FS.ReadDir(s.Abs(dirOrDot(dir)))
+	if err != nil {
+		return nil
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		name := joinGlob(dir, e.Name())
+		isDir := e.IsDir()
+		if !isDir && !dirsOnly {
+			*matches = append(*matches, name)
+		}
+		if isDir {
+			*matches = append(*matches, name)
+			if err := s.globAll(name, matches, dirsOnly); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func dirOrDot(dir string) string {
+	if dir == "" {
+		return "."
+	}
+	return dir
+}
+
+func joinGlob(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	if strings.HasSuffix(dir, "/") {
+		return dir + name
+	}
+	return dir + "/" + name
+}
+
+// hasGlobChars reports if a pattern has unescaped "*", "?" or a
+// "[" with a "]" after it
+func hasGlobChars(pat string) bool {
+	for i := 0; i < len(pat); i++ {
+		switch pat[i] {
+		case '\\':
+			i++
+		case '*', '?':
+			return true
+		case '[':
+			if strings.IndexByte(pat[i+1:], ']') != -1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validPattern reports if filepath.Match accepts a pattern
+func validPattern(pat string) bool {
+	_, err := filepath.Match(pat, "")
+	return err == nil
+}
+
+// unescapeGlob removes the backslashes from a pattern
+func unescapeGlob(pat string) string {
+	if strings.IndexByte(pat, '\\') == -1 {
+		return pat
+	}
+	var buf strings.Builder
+	for i := 0; i < len(pat); i++ {
+		if pat[i] == '\\' && i+1 < len(pat) {
+			i++
+		}
+		buf.WriteByte(pat[i])
+	}
+	return buf.String()
+}
+
+// skipQuoted returns the index of the last byte of the quoted
+// string, escaped character or "${...}" at str[i], or i if there
+// is none.
+func skipQuoted(str string, i int) int {
+	last := len(str) - 1
+	switch str[i] {
+	case '\\':
+		if i < last {
+			return i + 1
+		}
+	case '\'':
+		if end := strings.IndexByte(str[i+1:], '\''); end != -1 {
+			return i + 1 + end
+		}
+		return last
+	case '"':
+		for j := i + 1; j < len(str); j++ {
+			switch str[j] {
+			case '\\':
+				j++
+			case '"':
+				return j
+			}
+		}
+		return last
+	case '$':
+		if strings.HasPrefix(str[i:], "$((") {
+			if end := matchArith(str, i); end != -1 {
+				return end - 1
+			}
+			return last
+		}
+		if i < last && str[i+1] == '{' {
+			if end := matchBrace(str, i+1); end != -1 {
+				return end
+			}
+			return last
+		}
+	}
+	return i
+}
+
+// maxBraceWords is the most words brace expansion can make, so
+// "{1..999999999}" fails instead of using up memory
+const maxBraceWords = 100000
+
+// expandBraces does brace expansion on each word of a command.
+// Quoted braces, "${...}" and braces without a "," or ".." are
+// left alone, so "{}" stays as is.
+func expandBraces(cmd string) (string, error) {
+	if strings.IndexByte(cmd, '{') == -1 {
+		return cmd, nil
+	}
+	var words []string
+	for _, w := range rawWords(cmd) {
+		out, err := braceWord(w)
+		if err != nil {
+			return "", err
+		}
+		words = append(words, out...)
+		if len(words) > maxBraceWords {
+			return "", fmt.Errorf("brace expansion makes more than %d words", maxBraceWords)
+		}
+	}
+	return strings.Join(words, " "), nil
+}
+
+// rawWords splits a command into words before any expansion,
+// keeping the quotes.
+func rawWords(cmd string) []string {
+	var words []string
+	start := -1
+	for i := 0; i < len(cmd); i++ {
+		switch cmd[i] {
+		case ' ', '\t', '\n', '\r':
+			if start != -1 {
+				words = append(words, cmd[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start == -1 {
+			start = i
+		}
+		i = skipQuoted(cmd, i)
+	}
+	if start != -1 {
+		words = append(words, cmd[start:])
+	}
+	return words
+}
+
+// braceWord expands the braces in one word
+func braceWord(word string) ([]string, error) {
+	for open := 0; open < len(word); open++ {
+		if word[open] != '{' {
+			open = skipQuoted(word, open)
+			continue
+		}
+		end, commas := -1, []int(nil)
+		depth := 0
+	SCAN:
+		for i := open + 1; i < len(word); i++ {
+			switch word[i] {
+			case '{':
+				depth++
+			case '}':
+				if depth == 0 {
+					end = i
+					break SCAN
+				}
+				depth--
+			case ',':
+				if depth == 0 {
+					commas = append(commas, i)
+				}
+			default:
+				i = skipQuoted(word, i)
+			}
+		}
+		if end == -1 {
+			return []string{word}, nil
+		}
+
+		prefix, suffix := word[:open], word[end+1:]
+		var alts []string
+		if len(commas) > 0 {
+			start := open + 1
+			for _, c := range append(commas, end) {
+				alts = append(alts, word[start:c])
+				start = c + 1
+			}
+		} else {
+			var err error
+			if alts, err = braceRange(word[open+1 : end]); err != nil {
+				return nil, err
+			}
+			if alts == nil {
+				continue
+			}
+		}
+		var out []string
+		for _, alt := range alts {
+			words, err := braceWord(prefix + alt + suffix)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, words...)
+			if len(out) > maxBraceWords {
+				return nil, fmt.Errorf("%s: brace expansion makes more than %d words", word, maxBraceWords)
+			}
+		}
+		return out, nil
+	}
+	return []string{word}, nil
+}
+
+// braceRange expands "1..5", "a..e" or "0..10..2", or returns nil
+// if spec is not a range.  A range of more than maxBraceWords is an
+// error.
+func braceRange(spec string) ([]string, error) {
+	parts := strings.Split(spec, "..")
+	if len(parts) != 2 && len(parts) != 3 {
+		return nil, nil
+	}
+	step := 1
+	if len(parts) == 3 {
+		n, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return nil, nil
+		}
+		if n < 0 {
+			n = -n
+		}
+		if n != 0 {
+			step = n
+		}
+	}
+
+	var out []string
+	from, ferr := strconv.Atoi(parts[0])
+	to, terr := strconv.Atoi(parts[1])
+	switch {
+	case ferr == nil && terr == nil:
+		width := 0
+		if isPadded(parts[0]) || isPadded(parts[1]) {
+			width = max(len(parts[0]), len(parts[1]))
+		}
+		// counted without overflow, as the ends can be any int
+		dir, diff := 1, uint64(to)-uint64(from)
+		if from > to {
+			dir, diff = -1, uint64(from)-uint64(to)
+		}
+		count := diff / uint64(step)
+		if count >= maxBraceWords {
+			return nil, fmt.Errorf("{%s}: brace expansion makes more than %d words", spec, maxBraceWords)
+		}
+		for i := 0; i <= int(count); i++ {
+			out = append(out, fmt.Sprintf("%0*d", width, from+dir*i*step))
+		}
+	case len(parts[0]) == 1 && len(parts[1]) == 1 && isLetter(parts[0][0]) && isLetter(parts[1][0]):
+		a, b := parts[0][0], parts[1][0]
+		if a <= b {
+			for c := int(a); c <= int(b); c += step {
+				out = append(out, string(rune(c)))
+			}
+		} else {
+			for c := int(a); c >= int(b); c -= step {
+				out = append(out, string(rune(c)))
+			}
+		}
+	}
+	return out, nil
+}
+
+// isPadded reports if a number in a range has leading zeros
+func isPadded(n string) bool {
+	n = strings.TrimPrefix(n, "-")
+	return len(n) > 1 && n[0] == '0'
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
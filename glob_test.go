@@ -0,0 +1,114 @@
+package gsh
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGlob(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a1", "a2", "b[", ".hidden"} {
+		os.WriteFile(filepath.Join(dir, name), nil, 0644)
+	}
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	os.WriteFile(filepath.Join(dir, "sub", "c.txt"), nil, 0644)
+
+	tests := []struct {
+		script string
+		want   string
+	}{
+		{"echo a*", "a1 a2"},
+		{"echo a?", "a1 a2"},
+		{"echo a[12]", "a1 a2"},
+		{"echo 'a*'", "a*"},
+		{"echo a\\*", "a*"},
+		{"echo *", "a1 a2 b[ sub"},
+		{"echo .h*", ".hidden"},
+		{"echo */*.txt", "sub/c.txt"},
+		{"echo x*", "x*"},
+		{"echo a[", "a["},
+		{"echo b[", "b["},
+		{"echo [", "["},
+		{"echo a[]", "a[]"},
+		{"echo a[3-]", "a[3-]"},
+		{"echo [ -d / ]", "[ -d / ]"},
+		{"[ -d / ]", ""},
+		{"[ -f a1 ]", ""},
+		{"test a[ = a[", ""},
+		{"set -f\necho a*", "a*"},
+	}
+	for _, tt := range tests {
+		s := New()
+		var out strings.Builder
+		s.Stdout = &out
+		s.Exec("cd " + dir)
+		if err := s.Exec(tt.script); err != nil {
+			t.Errorf("%q: %v", tt.script, err)
+			continue
+		}
+		if got := strings.TrimSpace(out.String()); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.script, got, tt.want)
+		}
+	}
+}
+
+func TestBraces(t *testing.T) {
+	tests := []struct {
+		script string
+		want   string
+	}{
+		{"echo a{b,c}d '{b,c}'", "abd acd {b,c}"},
+		{"echo {a,b}{1,2}", "a1 a2 b1 b2"},
+		{"echo a{b,{c,d}}", "ab ac ad"},
+		{"echo {1..5}", "1 2 3 4 5"},
+		{"echo {5..1..2}", "5 3 1"},
+		{"echo {01..03}", "01 02 03"},
+		{"echo {a..e..2}", "a c e"},
+		{"echo {} {a} {1..}", "{} {a} {1..}"},
+		{"echo \"{a,b}\" \\{a,b}", "{a,b} {a,b}"},
+		{"x=1\necho ${x}{a,b}", "1a 1b"},
+		{"set +B\necho {a,b}", "{a,b}"},
+	}
+	for _, tt := range tests {
+		s := New()
+		var out strings.Builder
+		s.Stdout = &out
+		if err := s.Exec(tt.script); err != nil {
+			t.Errorf("%q: %v", tt.script, err)
+			continue
+		}
+		if out.String() != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.script, out.String(), tt.want)
+		}
+	}
+}
+
+func TestBracesTooMany(t *testing.T) {
+	tests := []string{
+		"echo {1..999999999}",
+		"echo {-9223372036854775808..9223372036854775807}",
+		"echo {1..1000}{1..1000}",
+		"echo {1..400} {1..400}{1..400}",
+	}
+	for _, script := range tests {
+		s := New()
+		var out strings.Builder
+		s.Stdout = &out
+		err := s.Exec(script)
+		if err == nil || !strings.Contains(err.Error(), "brace expansion makes more than") {
+			t.Errorf("%q: got %v, want too many words", script, err)
+		}
+		if out.Len() != 0 {
+			t.Errorf("%q: wrote %d bytes", script, out.Len())
+		}
+	}
+
+	s := New()
+	var out strings.Builder
+	s.Stdout = &out
+	if err := s.Exec("echo {2..100000}"); err != nil {
+		t.Errorf("largest range: %v", err)
+	}
+}
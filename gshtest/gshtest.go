@@ -0,0 +1,321 @@
+// Package gshtest runs gsh scripts and checks their output against
+// golden files.
+//
+// Each test is a txtar file.  The comment at the top describes the
+// test, and the files are:
+//
+//	-- script --        the gsh script to run
+//	-- stdout --        the expected stdout
+//	-- stderr --        the expected stderr
+//	-- exit --          the expected exit code, 0 if missing
+//	-- bin/NAME --      a command put first in PATH, such as a stub
+//	-- http/HOST/PATH --  served for http://HOST/PATH and https
+//	-- NAME --          any other file is written to the work directory
+//
+// For example:
+//
+//	fetch and check a file
+//	-- script --
+//	wget -O data.txt https://example.com/data.txt
+//	sha256sum data.txt
+//	-- http/example.com/data.txt --
+//	hello
+//	-- stdout --
+//	5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03  data.txt
+//
+// The script runs in a new temporary directory with a fixed
+// environment: HOME and PWD are the work directory, PATH is the
+// bin directory followed by /usr/local/bin:/usr/bin:/bin, LANG is C
+// and TZ is UTC.  The work directory is shown as $WORK in the output.
+// wget and http_last_mod are answered by a fake server with the http
+// files, and requests for anything else get a 404.
+//
+// If the script fails, its error is written to stderr as
+// "script:LINE: message", like the gsh command does.
+//
+// To use from go test:
+//
+//	func TestScripts(t *testing.T) {
+//		gshtest.Run(t, "testdata/*.txtar")
+//	}
+//
+// Run "go test -update" to rewrite the golden files with the output
+// of the scripts.  A test package using gshtest must not define its
+// own -update flag.
+package gshtest
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/client9/gsh"
+)
+
+var update = flag.Bool("update", false, "update gshtest golden files")
+
+// modTime is the Last-Modified time of the fake http files
+var modTime = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Run runs each file matching pattern as a subtest
+func Run(t *testing.T, pattern string) {
+	t.Helper()
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("no test files match %s", pattern)
+	}
+	for _, fname := range files {
+		name := strings.TrimSuffix(filepath.Base(fname), filepath.Ext(fname))
+		t.Run(name, func(t *testing.T) {
+			diff, err := Check(fname, *update)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff != "" {
+				t.Errorf("%s:\n%s", fname, diff)
+			}
+		})
+	}
+}
+
+// Check runs the test in a txtar file.  It returns how the output
+// was different from the golden files, or "" if it matched.  With
+// update the golden files are rewritten instead.
+func Check(fname string, update bool) (string, error) {
+	raw, err := os.ReadFile(fname)
+	if err != nil {
+		return "", err
+	}
+	a := parseArchive(raw)
+	script, ok := a.get("script")
+	if !ok {
+		return "", fmt.Errorf("%s: no script", fname)
+	}
+	got, err := runScript(a, string(script))
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", fname, err)
+	}
+
+	if update {
+		a.set("stdout", nonEmpty(got.stdout))
+		a.set("stderr", nonEmpty(got.stderr))
+		a.set("exit", nil)
+		if got.exit != 0 {
+			a.set("exit", []byte(strconv.Itoa(got.exit)+"\n"))
+		}
+		return "", os.WriteFile(fname, a.format(), 0644)
+	}
+
+	var msgs []string
+	for _, name := range []string{"stdout", "stderr"} {
+		want, _ := a.get(name)
+		out := got.stdout
+		if name == "stderr" {
+			out = got.stderr
+		}
+		if string(want) != out {
+			msgs = append(msgs, name+":\n"+diff(string(want), out))
+		}
+	}
+	want := 0
+	if code, ok := a.get("exit"); ok {
+		want, err = strconv.Atoi(strings.TrimSpace(string(code)))
+		if err != nil {
+			return "", fmt.Errorf("%s: bad exit code: %s", fname, err)
+		}
+	}
+	if want != got.exit {
+		msgs = append(msgs, fmt.Sprintf("exit code: want %d, got %d\n", want, got.exit))
+	}
+	return strings.Join(msgs, ""), nil
+}
+
+func nonEmpty(s string) []byte {
+	if s == "" {
+		return nil
+	}
+	return []byte(s)
+}
+
+// result is the output of a script
+type result struct {
+	stdout string
+	stderr string
+	exit   int
+}
+
+// runScript runs a script in a new work directory
+func runScript(a *archive, script string) (*result, error) {
+	root, err := os.MkdirTemp("", "gshtest")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(root)
+	work := filepath.Join(root, "work")
+	bin := filepath.Join(root, "bin")
+	for _, dir := range []string{work, bin} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range a.files {
+		var dest string
+		mode := os.FileMode(0644)
+		switch {
+		case f.name == "script" || f.name == "stdout" || f.name == "stderr" || f.name == "exit":
+			continue
+		case strings.HasPrefix(f.name, "http/"):
+			continue
+		case strings.HasPrefix(f.name, "bin/"):
+			dest, mode = filepath.Join(bin, f.name[4:]), 0755
+		default:
+			dest = filepath.Join(work, filepath.FromSlash(f.name))
+		}
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(dest, f.data, mode); err != nil {
+			return nil, err
+		}
+	}
+
+	server := httptest.NewServer(fileServer(a))
+	defer server.Close()
+
+	var stdout, stderr bytes.Buffer
+	s := gsh.New()
+	s.Stdout = &stdout
+	s.Stderr = &stderr
+	s.HTTPClient = &http.Client{Transport: fakeTransport{server.URL}}
+
+	// a fixed environment
+	vars := make([]string, 0, len(s.Env))
+	for k := range s.Env {
+		vars = append(vars, k)
+	}
+	if err := s.Cmd("unset", vars...).Run(); err != nil {
+		return nil, err
+	}
+	s.PutEnv("HOME", work)
+	s.PutEnv("PATH", bin+":/usr/local/bin:/usr/bin:/bin")
+	s.PutEnv("LANG", "C")
+	s.PutEnv("TZ", "UTC")
+	if err := s.Cmd("cd", work).Run(); err != nil {
+		return nil, err
+	}
+
+	err = s.Exec(script)
+	s.Close()
+	exit := 0
+	if err != nil {
+		var serr *gsh.ScriptError
+		if errors.As(err, &serr) {
+			fmt.Fprintf(&stderr, "script:%d: %s\n", serr.Line, serr.Message())
+			exit = serr.ExitCode
+		} else {
+			fmt.Fprintf(&stderr, "script: %s\n", err)
+		}
+		if exit <= 0 {
+			exit = 1
+		}
+	}
+	r := strings.NewReplacer(work, "$WORK", root, "$ROOT")
+	return &result{
+		stdout: r.Replace(stdout.String()),
+		stderr: r.Replace(stderr.String()),
+		exit:   exit,
+	}, nil
+}
+
+// fileServer serves the "http/HOST/PATH" files of the archive
+func fileServer(a *archive) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := "http" + r.URL.Path
+		if strings.HasSuffix(name, "/") {
+			name += "index.html"
+		}
+		data, ok := a.get(name)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, name, modTime, bytes.NewReader(data))
+	})
+}
+
+// fakeTransport sends all requests to the fake server, with the
+// original host as the first part of the path.
+type fakeTransport struct {
+	server string
+}
+
+func (t fakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	u, err := url.Parse(t.server)
+	if err != nil {
+		return nil, err
+	}
+	r := req.Clone(req.Context())
+	r.URL.Scheme = u.Scheme
+	r.URL.Host = u.Host
+	r.URL.Path = "/" + req.URL.Hostname() + req.URL.Path
+	r.Host = u.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// diff shows the lines of want and got that differ
+func diff(want, got string) string {
+	a := strings.SplitAfter(want, "\n")
+	b := strings.SplitAfter(got, "\n")
+
+	// longest common subsequence
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var buf strings.Builder
+	line := func(prefix, text string) {
+		if text == "" {
+			return
+		}
+		buf.WriteString(prefix + strings.TrimSuffix(text, "\n") + "\n")
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			line("  ", a[i])
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			line("+ ", b[j])
+			j++
+		default:
+			line("- ", a[i])
+			i++
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,55 @@
+package gshtest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScripts(t *testing.T) {
+	Run(t, "testdata/*.txtar")
+}
+
+func TestCheckMismatch(t *testing.T) {
+	tests := []struct {
+		name string
+		test string
+		want string // in the report, "" if it matches
+	}{
+		{"match", "-- script --\nprintf 'a\\n'\n-- stdout --\na\n", ""},
+		{"stdout", "-- script --\nprintf 'a\\n'\n-- stdout --\nb\n", "stdout"},
+		{"missing stdout", "-- script --\nprintf 'a\\n'\n", "stdout"},
+		{"exit", "-- script --\nfalse\n-- stderr --\nscript:1: false: exit status 1\n", "exit"},
+	}
+	for _, tt := range tests {
+		fname := filepath.Join(t.TempDir(), "test.txtar")
+		os.WriteFile(fname, []byte(tt.test), 0644)
+		report, err := Check(fname, false)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if (report == "") != (tt.want == "") || !strings.Contains(report, tt.want) {
+			t.Errorf("%s: got report %q, want %q", tt.name, report, tt.want)
+		}
+	}
+}
+
+func TestArchive(t *testing.T) {
+	data := "comment\n-- a --\nA\n-- b/c --\n\n-- d --\nno newline"
+	a := parseArchive([]byte(data))
+	if got, ok := a.get("a"); !ok || string(got) != "A\n" {
+		t.Errorf("a: %q", got)
+	}
+	if got, ok := a.get("b/c"); !ok || string(got) != "\n" {
+		t.Errorf("b/c: %q", got)
+	}
+	a.set("a", []byte("new\n"))
+	a.set("e", []byte("E\n"))
+	a.set("d", nil)
+	want := "comment\n-- a --\nnew\n-- b/c --\n\n-- e --\nE\n"
+	if got := string(a.format()); got != want {
+		t.Errorf("format: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,95 @@
+package gshtest
+
+import (
+	"bytes"
+	"strings"
+)
+
+// archive is a txtar file: a comment followed by named files.
+//
+//	comment
+//	-- name --
+//	contents
+type archive struct {
+	comment []byte
+	files   []file
+}
+
+type file struct {
+	name string
+	data []byte
+}
+
+// parseArchive parses txtar data
+func parseArchive(data []byte) *archive {
+	a := &archive{}
+	var cur *file
+	for len(data) > 0 {
+		line := data
+		rest := []byte(nil)
+		if i := bytes.IndexByte(data, '\n'); i != -1 {
+			line, rest = data[:i+1], data[i+1:]
+		}
+		if name, ok := fileMarker(line); ok {
+			a.files = append(a.files, file{name: name})
+			cur = &a.files[len(a.files)-1]
+		} else if cur == nil {
+			a.comment = append(a.comment, line...)
+		} else {
+			cur.data = append(cur.data, line...)
+		}
+		data = rest
+	}
+	return a
+}
+
+// fileMarker returns the name in a "-- name --" line
+func fileMarker(line []byte) (string, bool) {
+	s := strings.TrimRight(string(line), "\r\n")
+	if !strings.HasPrefix(s, "-- ") || !strings.HasSuffix(s, " --") || len(s) < 7 {
+		return "", false
+	}
+	name := strings.TrimSpace(s[3 : len(s)-3])
+	return name, name != ""
+}
+
+// format is the reverse of parseArchive
+func (a *archive) format() []byte {
+	var buf bytes.Buffer
+	buf.Write(a.comment)
+	for _, f := range a.files {
+		buf.WriteString("-- " + f.name + " --\n")
+		buf.Write(f.data)
+		if len(f.data) > 0 && f.data[len(f.data)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+// get returns a file, and if it exists
+func (a *archive) get(name string) ([]byte, bool) {
+	for _, f := range a.files {
+		if f.name == name {
+			return f.data, true
+		}
+	}
+	return nil, false
+}
+
+// set replaces a file, or adds it at the end.  A nil value removes it.
+func (a *archive) set(name string, data []byte) {
+	for i, f := range a.files {
+		if f.name == name {
+			if data == nil {
+				a.files = append(a.files[:i], a.files[i+1:]...)
+			} else {
+				a.files[i].data = data
+			}
+			return
+		}
+	}
+	if data != nil {
+		a.files = append(a.files, file{name: name, data: data})
+	}
+}
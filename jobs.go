@@ -0,0 +1,226 @@
+package gsh
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// job is a command running in the background
+type job struct {
+	id   int
+	cmd  string
+	proc *os.Process
+	p    *process
+	done chan struct{}
+	err  error
+}
+
+// state describes the job for the jobs command
+func (j *job) state() string {
+	select {
+	case <-j.done:
+	default:
+		return "Running"
+	}
+	if j.err == nil {
+		return "Done"
+	}
+	if exit, ok := j.err.(*exec.ExitError); ok {
+		return fmt.Sprintf("Exit %d", exit.ExitCode())
+	}
+	return "Failed"
+}
+
+// startJob starts an external command in the background.
+// The pid is available as "$!".
+func (s *Session) startJob(p *process) error {
+	if err := p.Start(); err != nil {
+		return err
+	}
+	j := &job{
+		id:   1,
+		cmd:  strings.TrimSpace(p.line),
+		proc: p.cmd.Process,
+		p:    p,
+		done: make(chan struct{}),
+	}
+	if n := len(s.jobs); n > 0 {
+		j.id = s.jobs[n-1].id + 1
+	}
+	go func() {
+		j.err = p.Wait()
+		close(j.done)
+	}()
+	s.jobs = append(s.jobs, j)
+	s.lastPid = strconv.Itoa(j.proc.Pid)
+	return nil
+}
+
+// findJob looks up a job by pid or "%n" job number
+func (s *Session) findJob(spec string) (*job, error) {
+	if strings.HasPrefix(spec, "%") {
+		id, err := strconv.Atoi(spec[1:])
+		if err == nil {
+			for _, j := range s.jobs {
+				if j.id == id {
+					return j, nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+	pid, err := strconv.Atoi(spec)
+	if err != nil {
+		return nil, fmt.Errorf("%s: arguments must be process or job IDs", spec)
+	}
+	for _, j := range s.jobs {
+		if j.proc.Pid == pid {
+			return j, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: no such job", spec)
+}
+
+// removeJob forgets about a job after it has been waited on
+func (s *Session) removeJob(j *job) {
+	for i, jj := range s.jobs {
+		if jj == j {
+			s.jobs = append(s.jobs[:i], s.jobs[i+1:]...)
+			return
+		}
+	}
+}
+
+// killJobs kills and reaps all outstanding jobs
+func (s *Session) killJobs() {
+	for _, j := range s.jobs {
+		j.p.kill()
+		<-j.done
+	}
+	s.jobs = nil
+}
+
+// Wait waits for background jobs to finish.
+//
+// With no args, all jobs are waited on and the result is always
+// success.  Otherwise the result is the status of the last job given.
+func Wait(s *Session, cli []string) error {
+	name, args := cli[0], cli[1:]
+	if len(args) == 0 {
+		for _, j := range s.jobs {
+			<-j.done
+		}
+		s.jobs = nil
+		return nil
+	}
+	var err error
+	for _, spec := range args {
+		j, ferr := s.findJob(spec)
+		if ferr != nil {
+			return fmt.Errorf("%s: %s", name, ferr)
+		}
+		<-j.done
+		s.removeJob(j)
+		err = j.err
+	}
+	return err
+}
+
+// Jobs lists the background jobs
+func Jobs(s *Session, cli []string) error {
+	name, fargs := cli[0], cli[1:]
+	f := flag.NewFlagSet(name, flag.ContinueOnError)
+	flagPid := f.Bool("p", false, "list only process IDs")
+	flagLong := f.Bool("l", false, "include process IDs")
+	err := f.Parse(fargs)
+	if err != nil {
+		return err
+	}
+	for _, j := range s.jobs {
+		switch {
+		case *flagPid:
+			fmt.Fprintf(s.Stdout, "%d\n", j.proc.Pid)
+		case *flagLong:
+			fmt.Fprintf(s.Stdout, "[%d] %d %-10s %s\n", j.id, j.proc.Pid, j.state(), j.cmd)
+		default:
+			fmt.Fprintf(s.Stdout, "[%d] %-10s %s\n", j.id, j.state(), j.cmd)
+		}
+	}
+	return nil
+}
+
+// signals are the signal names understood by kill
+var signals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"ABRT": syscall.SIGABRT,
+	"KILL": syscall.SIGKILL,
+	"ALRM": syscall.SIGALRM,
+	"TERM": syscall.SIGTERM,
+}
+
+// parseSignal converts "TERM", "SIGTERM" or "15" to a signal
+func parseSignal(name string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(name); err == nil {
+		return syscall.Signal(n), nil
+	}
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return 0, fmt.Errorf("%s: invalid signal specification", name)
+	}
+	return sig, nil
+}
+
+// Kill sends a signal, TERM by default, to jobs or processes.
+// Under a Policy only jobs of the session can be signaled.
+//
+//	kill [-s SIGNAL | -SIGNAL] pid|%job ...
+func Kill(s *Session, cli []string) error {
+	name, args := cli[0], cli[1:]
+	sig := syscall.SIGTERM
+	if len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		spec := args[0][1:]
+		args = args[1:]
+		if spec == "s" {
+			if len(args) == 0 {
+				return fmt.Errorf("%s: -s requires a signal", name)
+			}
+			spec, args = args[0], args[1:]
+		}
+		var err error
+		if sig, err = parseSignal(spec); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("%s: requires a pid or job", name)
+	}
+	for _, spec := range args {
+		j, err := s.findJob(spec)
+		if err == nil {
+			// the job and the rest of its pipeline
+			err = j.p.signal(sig)
+		} else if pid, perr := strconv.Atoi(spec); perr == nil {
+			if s.policy != nil {
+				return &PolicyError{Op: name, Name: spec, Err: ErrKillNotAllowed}
+			}
+			var proc *os.Process
+			if proc, err = os.FindProcess(pid); err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+			err = proc.Signal(sig)
+		} else {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: (%s) - %s", name, spec, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package gsh
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestJobs(t *testing.T) {
+	tests := []struct {
+		script string
+		want   string
+		code   int // exit status, -1 for an error that is not one
+	}{
+		{"sh -c 'exit 3' &\nwait $!", "", 3},
+		{"sh -c 'exit 3' &\nwait %1", "", 3},
+		{"sh -c 'exit 3' &\nwait", "", 0},
+		{"true &\nsh -c 'exit 4' &\nwait %2 %1", "", 0},
+		{"true &\nsh -c 'exit 4' &\nwait %1 %2", "", 4},
+		{"true &\nwait %1\nwait %1", "", -1},
+		{"sleep 5 &\nkill %1\nwait %1", "", 128 + int(syscall.SIGTERM)},
+		{"sleep 5 &\nkill -s KILL $!\nwait $!", "", 128 + int(syscall.SIGKILL)},
+		{"sleep 5 &\nkill -INT %1\nwait", "", 0},
+		{"sleep 5 &\nsleep 6 &\njobs", "[1] Running    sleep 5\n[2] Running    sleep 6\n", 0},
+		{"sh -c 'exit 2' &\ntrue &\nsleep 0.5\njobs", "[1] Exit 2     sh -c 'exit 2'\n[2] Done       true\n", 0},
+		{"sleep 5 &\nwait %1 &", "", -1},
+		{"wait %1", "", -1},
+		{"kill %1", "", -1},
+		{"kill -FOO 1", "", -1},
+		{"echo x &", "", -1},
+	}
+	for _, tt := range tests {
+		s := New()
+		var out strings.Builder
+		s.Stdout = &out
+		err := s.Exec(tt.script)
+		s.Close()
+		var serr *ScriptError
+		switch {
+		case tt.code == 0 && err != nil:
+			t.Errorf("%q: %v", tt.script, err)
+		case tt.code == -1 && err == nil:
+			t.Errorf("%q: no error", tt.script)
+		case tt.code > 0 && (!errors.As(err, &serr) || serr.ExitCode != tt.code):
+			t.Errorf("%q: got %v, want exit status %d", tt.script, err, tt.code)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.script, out.String(), tt.want)
+		}
+	}
+}
+
+func TestJobPid(t *testing.T) {
+	s := New()
+	var out strings.Builder
+	s.Stdout = &out
+	if err := s.Exec("sleep 5 &", "jobs -p", "echo $!"); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	listed, pid, _ := strings.Cut(out.String(), "\n")
+	if pid == "" || pid != listed {
+		t.Errorf("$! is %q, jobs -p is %q", pid, listed)
+	}
+	out.Reset()
+	s.Exec("jobs -l")
+	if want := "[1] " + pid + " Running    sleep 5\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestCloseKillsJobs(t *testing.T) {
+	s := New()
+	start := time.Now()
+	if err := s.Exec("sleep 5 &", "sleep 6 &"); err != nil {
+		t.Fatal(err)
+	}
+	procs := []*os.Process{s.jobs[0].proc, s.jobs[1].proc}
+	s.Close()
+	if time.Since(start) > 3*time.Second {
+		t.Errorf("Close took %s", time.Since(start))
+	}
+	if len(s.jobs) != 0 {
+		t.Errorf("%d jobs left", len(s.jobs))
+	}
+	for _, p := range procs {
+		// reaped, so the pid is no longer ours
+		if err := p.Signal(syscall.Signal(0)); err == nil {
+			t.Errorf("pid %d still running", p.Pid)
+		}
+	}
+}
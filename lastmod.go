@@ -0,0 +1,111 @@
+package gsh
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// All last-modified helpers normalize to RFC3339 in UTC so the
+// results from different sources can be compared as strings.
+
+// fileLastModified returns the modification time of a local file
+func fileLastModified(fsys FS, fname string) (string, error) {
+	info, err := fsys.Stat(fname)
+	if err != nil {
+		return "", err
+	}
+	return info.ModTime().UTC().Format(time.RFC3339), nil
+}
+
+// gitLastModified returns the commit time of the last change to a
+// git-tracked file, with git run in the session directory
+func (s *Session) gitLastModified(fname string) (string, error) {
+	git, err := s.lookPath("git")
+	if err != nil {
+		return "", err
+	}
+	if err := s.policy.checkCommand("git", git); err != nil {
+		return "", err
+	}
+	cmd := exec.CommandContext(s.context(), git, "log", "-n", "1", "--pretty=format:%cI", "--", fname)
+	cmd.Dir = s.dir
+	cmd.Env = s.environ()
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git log %s failed: %s", fname, err)
+	}
+	line := strings.TrimSpace(string(out))
+	if len(line) == 0 {
+		return "", fmt.Errorf("%s: not tracked by git", fname)
+	}
+	t, err := time.Parse(time.RFC3339, line)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse git date %q: %s", line, err)
+	}
+	return t.UTC().Format(time.RFC3339), nil
+}
+
+// httpLastModified returns the Last-Modified header of a URL
+func httpLastModified(client *http.Client, source string) (string, error) {
+	resp, err := client.Head(source)
+	if err != nil {
+		return "", fmt.Errorf("request failed: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("HEAD %s: %s", source, resp.Status)
+	}
+	lastModified := resp.Header.Get("Last-Modified")
+	if len(lastModified) == 0 {
+		return "", fmt.Errorf("%s: no Last-Modified field", source)
+	}
+	t, err := http.ParseTime(lastModified)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse Last-Modified %q: %s", lastModified, err)
+	}
+	return t.UTC().Format(time.RFC3339), nil
+}
+
+// FileLastModified prints the modification time of each file
+func FileLastModified(s *Session, cli []string) error {
+	return lastModified(s, cli, func(fname string) (string, error) {
+		return fileLastModified(s.FS, s.Abs(fname))
+	})
+}
+
+// GitLastModified prints the last commit time of each file
+func GitLastModified(s *Session, cli []string) error {
+	return lastModified(s, cli, func(fname string) (string, error) {
+		return s.gitLastModified(fname)
+	})
+}
+
+// HTTPLastModified prints the Last-Modified time of each URL
+func HTTPLastModified(s *Session, cli []string) error {
+	return lastModified(s, cli, func(u string) (string, error) {
+		if err := s.policy.checkURL("HEAD", u); err != nil {
+			return "", err
+		}
+		return httpLastModified(s.httpClient(), u)
+	})
+}
+
+func lastModified(s *Session, cli []string, fn func(string) (string, error)) error {
+	name, fargs := cli[0], cli[1:]
+	f := flag.NewFlagSet(name, flag.ContinueOnError)
+	err := f.Parse(fargs)
+	if err != nil {
+		return err
+	}
+	return ForEachLine(s, f.Args(), func(line []byte) ([]byte, error) {
+		out, err := fn(string(line))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		return []byte(out), nil
+	})
+}
@@ -0,0 +1,88 @@
+package gsh
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLastModified(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var gets atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			gets.Add(1)
+		}
+		http.ServeContent(w, r, "data", modTime, strings.NewReader("remote"))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		local    time.Time
+		download bool
+	}{
+		{"older", modTime.Add(-time.Hour), true},
+		{"same", modTime, false},
+		{"newer", modTime.Add(time.Hour), false},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		local := filepath.Join(dir, "data")
+		os.WriteFile(local, []byte("local"), 0644)
+		os.Chtimes(local, tt.local, tt.local)
+
+		s := New()
+		var out strings.Builder
+		s.Stdout = &out
+		s.Exec("cd " + dir)
+		s.Env["URL"] = ts.URL + "/data"
+		if err := s.Exec("http_last_mod $URL", "file_last_mod data"); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		want := modTime.Format(time.RFC3339) + "\n" + tt.local.UTC().Format(time.RFC3339) + "\n"
+		if out.String() != want {
+			t.Errorf("%s: got %q, want %q", tt.name, out.String(), want)
+		}
+
+		gets.Store(0)
+		if s.Test(`gt (httpLastModified ${URL}) (fileLastModified "data")`) {
+			s.Exec("wget -O data $URL")
+		}
+		if err := s.Error(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		b, _ := os.ReadFile(local)
+		switch {
+		case tt.download && (string(b) != "remote" || gets.Load() != 1):
+			t.Errorf("%s: not downloaded, file is %q", tt.name, b)
+		case !tt.download && (string(b) != "local" || gets.Load() != 0):
+			t.Errorf("%s: downloaded, file is %q", tt.name, b)
+		}
+	}
+}
+
+func TestLastModifiedErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	for _, cmd := range []string{
+		"file_last_mod " + filepath.Join(t.TempDir(), "missing"),
+		"http_last_mod " + ts.URL + "/missing",
+		"http_last_mod " + ts.URL + "/no-header",
+	} {
+		s := New()
+		s.Stdout = &strings.Builder{}
+		if err := s.Exec(cmd); err == nil {
+			t.Errorf("%s: no error", cmd)
+		}
+	}
+}
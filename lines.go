@@ -0,0 +1,163 @@
+package gsh
+
+import (
+	"bufio"
+	"context"
+	"iter"
+	"os"
+)
+
+// maxLine is the longest line a Scanner returns
+const maxLine = 1024 * 1024
+
+// Scanner reads the output of a script or command line by line,
+// while it runs.  It works like bufio.Scanner:
+//
+//	sc := s.Scanner("make all")
+//	defer sc.Close()
+//	for sc.Scan() {
+//		fmt.Println(sc.Text())
+//	}
+//	if err := sc.Err(); err != nil {
+//		...
+//	}
+//
+// The script runs in a clone of the session, like one side of a
+// pipe, so changes it makes to variables or the working directory
+// are not kept.  Calling Close before the end stops the script and
+// kills any command still running.
+type Scanner struct {
+	scanner *bufio.Scanner
+	r       *os.File
+	cancel  context.CancelFunc
+	done    chan struct{}
+	runErr  error
+	err     error
+	closed  bool
+}
+
+// Scanner runs a script and returns a Scanner for its output
+func (s *Session) Scanner(script string) *Scanner {
+	return s.scan(func(c *Session) error {
+		return c.Exec(script)
+	})
+}
+
+// Scanner runs the command and returns a Scanner for its output
+func (c *Cmd) Scanner() *Scanner {
+	return c.s.scan(c.run)
+}
+
+// Lines runs a script and returns its output as it arrives.  If
+// the script fails the error is the last value.  Stopping early
+// stops the script.
+//
+//	for line, err := range s.Lines("tail -n 100 build.log") {
+//		...
+//	}
+func (s *Session) Lines(script string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		s.Scanner(script).lines(yield)
+	}
+}
+
+// Lines runs the command and returns its output as it arrives
+func (c *Cmd) Lines() iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		c.Scanner().lines(yield)
+	}
+}
+
+// scan starts run in a clone with stdout going to a pipe.  Like
+// Exec, nothing is run if the session already has an error.
+func (s *Session) scan(run func(c *Session) error) *Scanner {
+	sc := &Scanner{done: make(chan struct{})}
+	err := s.Error()
+	var r, w *os.File
+	if err == nil {
+		r, w, err = os.Pipe()
+	}
+	if err != nil {
+		sc.err = err
+		sc.closed = true
+		close(sc.done)
+		return sc
+	}
+	ctx, cancel := context.WithCancel(s.context())
+	c := s.Clone()
+	c.ctx = ctx
+	c.Stdout = w
+
+	sc.r = r
+	sc.cancel = cancel
+	sc.scanner = bufio.NewScanner(r)
+	sc.scanner.Buffer(nil, maxLine)
+	go func() {
+		sc.runErr = run(c)
+		c.Close()
+		w.Close()
+		close(sc.done)
+	}()
+	return sc
+}
+
+// Scan advances to the next line, and returns false at the end of
+// the output or on an error.
+func (sc *Scanner) Scan() bool {
+	if sc.closed {
+		return false
+	}
+	if sc.scanner.Scan() {
+		return true
+	}
+	if err := sc.scanner.Err(); err != nil {
+		// the script may be blocked writing the rest, so stop
+		// it before waiting
+		sc.Close()
+		sc.err = err
+		return false
+	}
+	<-sc.done
+	sc.err = sc.runErr
+	sc.Close()
+	return false
+}
+
+// Text returns the current line, without the newline
+func (sc *Scanner) Text() string {
+	if sc.scanner == nil {
+		return ""
+	}
+	return sc.scanner.Text()
+}
+
+// Err returns the error from the script, or from reading its output
+func (sc *Scanner) Err() error {
+	return sc.err
+}
+
+// Close stops the script if it is still running and waits for it.
+// An error caused by stopping it early is not reported.
+func (sc *Scanner) Close() error {
+	if sc.closed {
+		return sc.err
+	}
+	sc.closed = true
+	sc.cancel()
+	sc.r.Close()
+	<-sc.done
+	return sc.err
+}
+
+// lines is the iterator form of a Scanner
+func (sc *Scanner) lines(yield func(string, error) bool) {
+	defer sc.Close()
+	for sc.Scan() {
+		if !yield(sc.Text(), nil) {
+			return
+		}
+	}
+	if err := sc.Err(); err != nil {
+		yield("", err)
+	}
+}
@@ -0,0 +1,109 @@
+package gsh
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		script string
+		want   []string
+		err    bool
+	}{
+		{"printf 'a\\n'\nprintf 'b\\n'", []string{"a", "b"}, false},
+		{"printf 'a\\n'\nsh -c 'echo b; exit 5'", []string{"a", "b"}, true},
+		{"true", nil, false},
+	}
+	for _, tt := range tests {
+		var got []string
+		var err error
+		for line, lerr := range New().Lines(tt.script) {
+			if lerr != nil {
+				err = lerr
+				break
+			}
+			got = append(got, line)
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") || (err != nil) != tt.err {
+			t.Errorf("%q: got %q, %v", tt.script, got, err)
+		}
+	}
+}
+
+func TestLinesStop(t *testing.T) {
+	start := time.Now()
+	n := 0
+	for range New().Lines("yes") {
+		if n++; n == 3 {
+			break
+		}
+	}
+	sc := New().Scanner("sleep 10")
+	sc.Close()
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("took %s to stop", time.Since(start))
+	}
+}
+
+func TestScannerTooLong(t *testing.T) {
+	s := New()
+	s.Env["BIG"] = strings.Repeat("a", 3*maxLine)
+	scanners := map[string]func() *Scanner{
+		"builtin": func() *Scanner { return s.Scanner("echo $BIG\necho $BIG") },
+		"command": func() *Scanner {
+			return s.Cmd("sh", "-c", "head -c 3000000 /dev/zero | tr '\\0' a; echo; echo b").Scanner()
+		},
+	}
+	for name, scanner := range scanners {
+		done := make(chan error)
+		go func() {
+			sc := scanner()
+			for sc.Scan() {
+			}
+			done <- sc.Err()
+		}()
+		select {
+		case err := <-done:
+			if !errors.Is(err, bufio.ErrTooLong) {
+				t.Errorf("%s: got %v, want %v", name, err, bufio.ErrTooLong)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: Scan did not return", name)
+		}
+	}
+}
+
+func TestScannerSessionError(t *testing.T) {
+	s := New()
+	s.Exec("false")
+	prev := s.Error()
+	if prev == nil {
+		t.Fatal("no session error")
+	}
+	dir := t.TempDir()
+	scanners := map[string]*Scanner{
+		"script":  s.Scanner("touch " + dir + "/script\necho a"),
+		"command": s.Cmd("touch", dir+"/command").Scanner(),
+	}
+	for name, sc := range scanners {
+		if sc.Scan() {
+			t.Errorf("%s: got line %q", name, sc.Text())
+		}
+		if !errors.Is(sc.Err(), prev) || sc.Close() != sc.Err() {
+			t.Errorf("%s: got %v, want %v", name, sc.Err(), prev)
+		}
+		if _, err := os.Stat(dir + "/" + name); err == nil {
+			t.Errorf("%s: ran with a session error", name)
+		}
+	}
+	for line, err := range s.Lines("echo a") {
+		if !errors.Is(err, prev) {
+			t.Errorf("lines: got %q, %v", line, err)
+		}
+	}
+}
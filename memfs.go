@@ -0,0 +1,483 @@
+package gsh
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errNotDir   = errors.New("not a directory")
+	errIsDir    = errors.New("is a directory")
+	errNotEmpty = errors.New("directory not empty")
+	errLoop     = errors.New("too many levels of symbolic links")
+)
+
+// MemFS is a file system kept in memory, for tests that should not
+// touch the disk.  It starts with only the root directory, so create
+// the session working directory before using it:
+//
+//	mem := gsh.NewMemFS()
+//	mem.MkdirAll("/work", 0777)
+//	s := gsh.New()
+//	s.FS = mem
+//	s.Exec("cd /work")
+//
+// Permission bits are kept but not enforced.  It is safe for
+// concurrent use.
+type MemFS struct {
+	mu    sync.Mutex
+	nodes map[string]*memNode
+}
+
+// memNode is a file, directory or symlink.  Hard links share a node.
+type memNode struct {
+	mode    fs.FileMode
+	modTime time.Time
+	data    []byte
+	link    string
+}
+
+// NewMemFS returns an empty in-memory file system
+func NewMemFS() *MemFS {
+	root := string(filepath.Separator)
+	return &MemFS{nodes: map[string]*memNode{
+		root: {mode: fs.ModeDir | 0777, modTime: time.Now()},
+	}}
+}
+
+// lookup resolves name to its key and node, following symlinks in
+// the directories, and in the last element if follow is set.  The
+// node is nil if only the last element does not exist.
+func (m *MemFS) lookup(name string, follow bool) (string, *memNode, error) {
+	name = filepath.Clean(name)
+	if !filepath.IsAbs(name) {
+		return "", nil, fs.ErrInvalid
+	}
+	return m.resolve(name, follow, 0)
+}
+
+func (m *MemFS) resolve(name string, follow bool, links int) (string, *memNode, error) {
+	if links > 40 {
+		return "", nil, errLoop
+	}
+	dir := filepath.Dir(name)
+	if dir == name {
+		return name, m.nodes[name], nil
+	}
+	dir, parent, err := m.resolve(dir, true, links)
+	if err != nil {
+		return "", nil, err
+	}
+	if parent == nil {
+		return "", nil, fs.ErrNotExist
+	}
+	if !parent.mode.IsDir() {
+		return "", nil, errNotDir
+	}
+	key := filepath.Join(dir, filepath.Base(name))
+	n := m.nodes[key]
+	if n == nil || !follow || n.mode&fs.ModeSymlink == 0 {
+		return key, n, nil
+	}
+	target := n.link
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(dir, target)
+	}
+	return m.resolve(filepath.Clean(target), true, links+1)
+}
+
+// find returns an existing node
+func (m *MemFS) find(op string, name string, follow bool) (string, *memNode, error) {
+	key, n, err := m.lookup(name, follow)
+	if err == nil && n == nil {
+		err = fs.ErrNotExist
+	}
+	if err != nil {
+		return "", nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return key, n, nil
+}
+
+// OpenFile opens a file.  The flags are those of os.OpenFile.
+func (m *MemFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, n, err := m.lookup(name, true)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	writing := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	switch {
+	case n == nil && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case n == nil:
+		n = &memNode{mode: perm.Perm(), modTime: time.Now()}
+		m.nodes[key] = n
+	case flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case n.mode.IsDir() && writing:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errIsDir}
+	case flag&os.O_TRUNC != 0 && writing:
+		n.data = nil
+		n.modTime = time.Now()
+	}
+	return &memFile{fs: m, node: n, name: name, flag: flag}, nil
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, n, err := m.find("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return n.info(filepath.Base(key)), nil
+}
+
+func (m *MemFS) Lstat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, n, err := m.find("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return n.info(filepath.Base(key)), nil
+}
+
+// ReadDir returns the entries of a directory, sorted by name
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, n, err := m.find("readdirent", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !n.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdirent", Path: name, Err: errNotDir}
+	}
+	var entries []fs.DirEntry
+	for k, child := range m.nodes {
+		if k != key && filepath.Dir(k) == key {
+			entries = append(entries, fs.FileInfoToDirEntry(child.info(filepath.Base(k))))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (m *MemFS) Mkdir(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mkdir(name, perm)
+}
+
+func (m *MemFS) mkdir(name string, perm fs.FileMode) error {
+	key, n, err := m.lookup(name, false)
+	if err == nil && n != nil {
+		err = fs.ErrExist
+	}
+	if err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	m.nodes[key] = &memNode{mode: fs.ModeDir | perm.Perm(), modTime: time.Now()}
+	return nil
+}
+
+func (m *MemFS) MkdirAll(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mkdirAll(filepath.Clean(name), perm)
+}
+
+func (m *MemFS) mkdirAll(name string, perm fs.FileMode) error {
+	if _, n, err := m.lookup(name, true); err == nil && n != nil {
+		if n.mode.IsDir() {
+			return nil
+		}
+		return &fs.PathError{Op: "mkdir", Path: name, Err: errNotDir}
+	}
+	if dir := filepath.Dir(name); dir != name {
+		if err := m.mkdirAll(dir, perm); err != nil {
+			return err
+		}
+	}
+	return m.mkdir(name, perm)
+}
+
+// Remove removes a file or an empty directory
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, n, err := m.find("remove", name, false)
+	if err != nil {
+		return err
+	}
+	if n.mode.IsDir() && m.hasChildren(key) {
+		return &fs.PathError{Op: "remove", Path: name, Err: errNotEmpty}
+	}
+	delete(m.nodes, key)
+	return nil
+}
+
+func (m *MemFS) hasChildren(key string) bool {
+	for k := range m.nodes {
+		if k != key && filepath.Dir(k) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Rename moves a file or directory, replacing newname if it is a
+// file or an empty directory.
+func (m *MemFS) Rename(oldname string, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	from, n, err := m.lookup(oldname, false)
+	if err == nil && n == nil {
+		err = fs.ErrNotExist
+	}
+	if err != nil {
+		return linkErr(err)
+	}
+	to, old, err := m.lookup(newname, false)
+	if err != nil {
+		return linkErr(err)
+	}
+	if to == from {
+		return nil
+	}
+	prefix := from + string(filepath.Separator)
+	if strings.HasPrefix(to, prefix) {
+		return linkErr(fs.ErrInvalid)
+	}
+	if old != nil {
+		switch {
+		case old.mode.IsDir() && !n.mode.IsDir():
+			return linkErr(errIsDir)
+		case !old.mode.IsDir() && n.mode.IsDir():
+			return linkErr(errNotDir)
+		case old.mode.IsDir() && m.hasChildren(to):
+			return linkErr(errNotEmpty)
+		}
+	}
+	m.nodes[to] = n
+	delete(m.nodes, from)
+	if n.mode.IsDir() {
+		for k, child := range m.nodes {
+			if strings.HasPrefix(k, prefix) {
+				m.nodes[filepath.Join(to, k[len(prefix):])] = child
+				delete(m.nodes, k)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *MemFS) Chmod(name string, mode fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.find("chmod", name, true)
+	if err != nil {
+		return err
+	}
+	n.mode = n.mode&^fs.ModePerm | mode.Perm()
+	return nil
+}
+
+// Chtimes sets the modification time, the access time is not kept
+func (m *MemFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.find("chtimes", name, true)
+	if err != nil {
+		return err
+	}
+	n.modTime = mtime
+	return nil
+}
+
+func (m *MemFS) Symlink(oldname string, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, n, err := m.lookup(newname, false)
+	if err == nil && n != nil {
+		err = fs.ErrExist
+	}
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	m.nodes[key] = &memNode{mode: fs.ModeSymlink | 0777, modTime: time.Now(), link: oldname}
+	return nil
+}
+
+func (m *MemFS) Readlink(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.find("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if n.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return n.link, nil
+}
+
+// Link makes newname a hard link to the file oldname
+func (m *MemFS) Link(oldname string, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+	_, n, err := m.lookup(oldname, false)
+	if err == nil && n == nil {
+		err = fs.ErrNotExist
+	}
+	if err != nil {
+		return linkErr(err)
+	}
+	if n.mode.IsDir() {
+		return linkErr(fs.ErrPermission)
+	}
+	key, old, err := m.lookup(newname, false)
+	if err == nil && old != nil {
+		err = fs.ErrExist
+	}
+	if err != nil {
+		return linkErr(err)
+	}
+	m.nodes[key] = n
+	return nil
+}
+
+// info is a snapshot of a node as a fs.FileInfo
+func (n *memNode) info(name string) fs.FileInfo {
+	size := int64(len(n.data))
+	if n.mode&fs.ModeSymlink != 0 {
+		size = int64(len(n.link))
+	}
+	return memInfo{name: name, size: size, mode: n.mode, modTime: n.modTime}
+}
+
+type memInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (fi memInfo) Name() string       { return fi.name }
+func (fi memInfo) Size() int64        { return fi.size }
+func (fi memInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi memInfo) ModTime() time.Time { return fi.modTime }
+func (fi memInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi memInfo) Sys() any           { return nil }
+
+// memFile is an open MemFS file
+type memFile struct {
+	fs     *MemFS
+	node   *memNode
+	name   string
+	flag   int
+	offset int64
+	closed bool
+}
+
+func (f *memFile) check(op string, write bool) error {
+	var err error
+	switch {
+	case f.closed:
+		err = fs.ErrClosed
+	case write && f.flag&(os.O_WRONLY|os.O_RDWR) == 0:
+		err = fs.ErrPermission
+	case !write && f.flag&os.O_WRONLY != 0:
+		err = fs.ErrPermission
+	case f.node.mode.IsDir():
+		err = errIsDir
+	}
+	if err != nil {
+		return &fs.PathError{Op: op, Path: f.name, Err: err}
+	}
+	return nil
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	if f.offset >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if off >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(f.node.data))
+	}
+	if end := f.offset + int64(len(p)); end > int64(len(f.node.data)) {
+		f.node.data = append(f.node.data, make([]byte, end-int64(len(f.node.data)))...)
+	}
+	copy(f.node.data[f.offset:], p)
+	f.offset += int64(len(p))
+	f.node.modTime = time.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	return f.node.info(filepath.Base(f.name)), nil
+}
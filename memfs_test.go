@@ -0,0 +1,369 @@
+package gsh
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testMemFS has /a/f with "hello", the empty directory /a/b and
+// symlinks to both
+func testMemFS(t *testing.T) *MemFS {
+	m := NewMemFS()
+	for _, err := range []error{
+		m.MkdirAll("/a/b", 0755),
+		memWrite(m, "/a/f", os.O_WRONLY|os.O_CREATE, "hello"),
+		m.Symlink("f", "/a/link"),
+		m.Symlink("/a/b", "/a/dirlink"),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return m
+}
+
+func memWrite(fsys FS, name string, flag int, data string) error {
+	f, err := fsys.OpenFile(name, flag, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func memRead(fsys FS, name string) string {
+	data, err := readFile(fsys, name)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return string(data)
+}
+
+func TestMemFSOpen(t *testing.T) {
+	tests := []struct {
+		name string
+		flag int
+		data string
+		err  error
+		file string // file to read after, name if empty
+		want string
+	}{
+		{"/a/new", os.O_WRONLY | os.O_CREATE, "x", nil, "", "x"},
+		{"/a/new", os.O_WRONLY | os.O_CREATE | os.O_EXCL, "x", nil, "", "x"},
+		{"/a/f", os.O_WRONLY | os.O_CREATE | os.O_EXCL, "x", fs.ErrExist, "", "hello"},
+		{"/a/link", os.O_WRONLY | os.O_CREATE | os.O_EXCL, "x", fs.ErrExist, "/a/f", "hello"},
+		{"/a/f", os.O_WRONLY | os.O_CREATE | os.O_TRUNC, "x", nil, "", "x"},
+		{"/a/f", os.O_WRONLY, "HE", nil, "", "HEllo"},
+		{"/a/f", os.O_WRONLY | os.O_APPEND, "!", nil, "", "hello!"},
+		{"/a/f", os.O_RDWR | os.O_TRUNC, "", nil, "", ""},
+		{"/a/f", os.O_RDONLY | os.O_TRUNC, "", fs.ErrPermission, "", "hello"},
+		{"/a/link", os.O_WRONLY | os.O_APPEND, "!", nil, "/a/f", "hello!"},
+		{"/a/dirlink/g", os.O_WRONLY | os.O_CREATE, "g", nil, "/a/b/g", "g"},
+		{"/a/missing", os.O_RDONLY, "", fs.ErrNotExist, "", "error: open /a/missing: file does not exist"},
+		{"/x/y", os.O_WRONLY | os.O_CREATE, "", fs.ErrNotExist, "", "error: open /x/y: file does not exist"},
+		{"/a/f/y", os.O_WRONLY | os.O_CREATE, "", errNotDir, "", "error: open /a/f/y: not a directory"},
+		{"/a/b", os.O_WRONLY, "", errIsDir, "", "error: read /a/b: is a directory"},
+		{"a/f", os.O_RDONLY, "", fs.ErrInvalid, "/a/f", "hello"},
+	}
+	for _, tt := range tests {
+		m := testMemFS(t)
+		err := memWrite(m, tt.name, tt.flag, tt.data)
+		if tt.err == nil && err != nil || tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("%s %#o: got %v, want %v", tt.name, tt.flag, err, tt.err)
+		}
+		var perr *fs.PathError
+		if err != nil && !errors.As(err, &perr) {
+			t.Errorf("%s %#o: got %T, want an *fs.PathError", tt.name, tt.flag, err)
+		}
+		file := tt.file
+		if file == "" {
+			file = tt.name
+		}
+		if got := memRead(m, file); got != tt.want {
+			t.Errorf("%s %#o: %s has %q, want %q", tt.name, tt.flag, file, got, tt.want)
+		}
+	}
+
+	// a dangling symlink is followed to create its target
+	m := testMemFS(t)
+	m.Symlink("new", "/a/dangling")
+	if err := memWrite(m, "/a/dangling", os.O_WRONLY|os.O_CREATE, "x"); err != nil {
+		t.Fatal(err)
+	}
+	if got := memRead(m, "/a/new"); got != "x" {
+		t.Errorf("dangling symlink: got %q", got)
+	}
+
+	// the mode of a file only changes when it is created
+	m = testMemFS(t)
+	f, _ := m.OpenFile("/a/f", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	f.Close()
+	if info, _ := m.Stat("/a/f"); info.Mode() != 0644 {
+		t.Errorf("mode changed to %s", info.Mode())
+	}
+}
+
+func TestMemFSFile(t *testing.T) {
+	m := testMemFS(t)
+	f, err := m.OpenFile("/a/f", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 3)
+	if n, err := f.ReadAt(buf, 3); n != 2 || err != io.EOF || string(buf[:n]) != "lo" {
+		t.Errorf("ReadAt: got %d %q %v", n, buf[:n], err)
+	}
+	if n, err := f.Read(buf); n != 3 || err != nil || string(buf) != "hel" {
+		t.Errorf("Read: got %d %q %v", n, buf[:n], err)
+	}
+	// writes continue from the read offset, and extend the file
+	if _, err := io.WriteString(f, "p me"); err != nil {
+		t.Error(err)
+	}
+	if info, _ := f.Stat(); info.Size() != 7 || info.Name() != "f" {
+		t.Errorf("Stat: got %s %d", info.Name(), info.Size())
+	}
+	if err := f.Close(); err != nil {
+		t.Error(err)
+	}
+	if err := f.Close(); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("second Close: got %v", err)
+	}
+	if _, err := f.Read(buf); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("Read after Close: got %v", err)
+	}
+	if got := memRead(m, "/a/f"); got != "help me" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestMemFSRename(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		err  error
+		read map[string]string // files to read after
+	}{
+		{"/a/f", "/a/b/g", nil, map[string]string{"/a/b/g": "hello", "/a/f": "error: open /a/f: file does not exist"}},
+		{"/a/f", "/a/f", nil, map[string]string{"/a/f": "hello"}},
+		{"/a/b", "/c", nil, map[string]string{"/c/x": "x", "/c/sub/y": "y", "/a/b/x": "error: open /a/b/x: file does not exist"}},
+		{"/a/b", "/a/e", nil, map[string]string{"/a/e/x": "x", "/a/dirlink/x": "error: open /a/dirlink/x: file does not exist"}},
+		{"/a/b/x", "/a/f", nil, map[string]string{"/a/f": "x", "/a/link": "x"}},
+		{"/a/link", "/a/b/link", nil, map[string]string{"/a/b/link": "error: open /a/b/link: file does not exist"}},
+		{"/a/b/sub", "/a/empty", nil, map[string]string{"/a/empty/y": "y"}},
+		{"/a/f", "/a/b", errIsDir, map[string]string{"/a/f": "hello"}},
+		{"/a/b", "/a/f", errNotDir, map[string]string{"/a/b/x": "x"}},
+		{"/a/empty", "/a/b", errNotEmpty, nil},
+		{"/a/b", "/a/b/sub/c", fs.ErrInvalid, map[string]string{"/a/b/x": "x"}},
+		{"/a/missing", "/a/g", fs.ErrNotExist, nil},
+		{"/a/f", "/x/g", fs.ErrNotExist, map[string]string{"/a/f": "hello"}},
+	}
+	for _, tt := range tests {
+		m := testMemFS(t)
+		m.MkdirAll("/a/b/sub", 0755)
+		m.Mkdir("/a/empty", 0755)
+		memWrite(m, "/a/b/x", os.O_WRONLY|os.O_CREATE, "x")
+		memWrite(m, "/a/b/sub/y", os.O_WRONLY|os.O_CREATE, "y")
+		err := m.Rename(tt.from, tt.to)
+		if tt.err == nil && err != nil || tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("%s %s: got %v, want %v", tt.from, tt.to, err, tt.err)
+		}
+		for name, want := range tt.read {
+			if got := memRead(m, name); got != want {
+				t.Errorf("%s %s: %s has %q, want %q", tt.from, tt.to, name, got, want)
+			}
+		}
+	}
+
+	// a symlink is moved, not what it points to
+	m := testMemFS(t)
+	if err := m.Rename("/a/link", "/a/b/link"); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := m.Readlink("/a/b/link"); target != "f" || err != nil {
+		t.Errorf("Readlink: got %q, %v", target, err)
+	}
+	if got := memRead(m, "/a/f"); got != "hello" {
+		t.Errorf("target changed to %q", got)
+	}
+}
+
+func TestMemFSSymlink(t *testing.T) {
+	m := testMemFS(t)
+	m.Symlink("loop2", "/a/loop1")
+	m.Symlink("loop1", "/a/loop2")
+	m.Symlink("missing", "/a/dangling")
+	m.Symlink("../b", "/a/b/self")
+
+	tests := []struct {
+		name string
+		size int64
+		mode fs.FileMode
+		err  error // from Stat, Lstat always works
+	}{
+		{"/a/link", 5, 0644, nil},
+		{"/a/dirlink", 0, fs.ModeDir | 0755, nil},
+		{"/a/dirlink/self", 0, fs.ModeDir | 0755, nil},
+		{"/a/b/self/self/self", 0, fs.ModeDir | 0755, nil},
+		{"/a/loop1", 0, 0, errLoop},
+		{"/a/dangling", 0, 0, fs.ErrNotExist},
+	}
+	for _, tt := range tests {
+		info, err := m.Stat(tt.name)
+		if tt.err != nil {
+			var perr *fs.PathError
+			if !errors.Is(err, tt.err) || !errors.As(err, &perr) || perr.Op != "stat" {
+				t.Errorf("Stat %s: got %v, want %v", tt.name, err, tt.err)
+			}
+		} else if err != nil || info.Size() != tt.size || info.Mode() != tt.mode {
+			t.Errorf("Stat %s: got %v, want %d %s", tt.name, err, tt.size, tt.mode)
+		}
+		info, err = m.Lstat(tt.name)
+		if err != nil || info.Mode().Type() != fs.ModeSymlink {
+			t.Errorf("Lstat %s: got %v", tt.name, err)
+		}
+	}
+
+	if _, err := m.Stat("/a/loop1/x"); !errors.Is(err, errLoop) {
+		t.Errorf("loop in a directory: got %v", err)
+	}
+	if _, err := m.Readlink("/a/f"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Readlink of a file: got %v", err)
+	}
+	var lerr *os.LinkError
+	if err := m.Symlink("x", "/a/f"); !errors.Is(err, fs.ErrExist) || !errors.As(err, &lerr) {
+		t.Errorf("Symlink over a file: got %v", err)
+	}
+	if err := m.Symlink("x", "/x/y"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Symlink in a missing directory: got %v", err)
+	}
+
+	// hard links share the file
+	if err := m.Link("/a/f", "/a/hard"); err != nil {
+		t.Fatal(err)
+	}
+	memWrite(m, "/a/hard", os.O_WRONLY|os.O_APPEND, "!")
+	if got := memRead(m, "/a/f"); got != "hello!" {
+		t.Errorf("hard link: got %q", got)
+	}
+	if err := m.Link("/a/b", "/a/hardb"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Link of a directory: got %v", err)
+	}
+}
+
+func TestMemFSRemove(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		gone []string
+		kept []string
+	}{
+		{"/a/f", nil, []string{"/a/f"}, []string{"/a/link"}},
+		{"/a/link", nil, []string{"/a/link"}, []string{"/a/f"}},
+		{"/a/dirlink", nil, []string{"/a/dirlink"}, []string{"/a/b/x"}},
+		{"/a/b/sub", nil, []string{"/a/b/sub"}, []string{"/a/b"}},
+		{"/a/b", errNotEmpty, nil, []string{"/a/b/x"}},
+		{"/a/missing", fs.ErrNotExist, nil, nil},
+		{"/a/f/x", errNotDir, nil, []string{"/a/f"}},
+	}
+	for _, tt := range tests {
+		m := testMemFS(t)
+		m.Mkdir("/a/b/sub", 0755)
+		memWrite(m, "/a/b/x", os.O_WRONLY|os.O_CREATE, "x")
+		err := m.Remove(tt.name)
+		var perr *fs.PathError
+		if tt.err == nil && err != nil || tt.err != nil && (!errors.Is(err, tt.err) || !errors.As(err, &perr)) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+		for _, name := range tt.gone {
+			if _, err := m.Lstat(name); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("%s: %s still there", tt.name, name)
+			}
+		}
+		for _, name := range tt.kept {
+			if _, err := m.Lstat(name); err != nil {
+				t.Errorf("%s: %s removed", tt.name, name)
+			}
+		}
+	}
+
+	// FS has no RemoveAll, so a tree is removed bottom up
+	m := testMemFS(t)
+	m.MkdirAll("/a/b/c/d", 0755)
+	memWrite(m, "/a/b/c/x", os.O_WRONLY|os.O_CREATE, "x")
+	var names []string
+	walk(m, "/a", func(path string, info fs.FileInfo, err error) error {
+		names = append(names, path)
+		return err
+	})
+	slices.Reverse(names)
+	for _, name := range names {
+		if err := m.Remove(name); err != nil {
+			t.Error(err)
+		}
+	}
+	if entries, err := m.ReadDir("/"); err != nil || len(entries) != 0 {
+		t.Errorf("left %v, %v", entries, err)
+	}
+}
+
+func TestMemFSStat(t *testing.T) {
+	m := testMemFS(t)
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := m.Chtimes("/a/link", mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Chmod("/a/link", 0600); err != nil {
+		t.Fatal(err)
+	}
+	info, err := m.Stat("/a/link")
+	if err != nil || info.Name() != "f" || info.Size() != 5 || info.Mode() != 0600 ||
+		!info.ModTime().Equal(mtime) || info.IsDir() {
+		t.Errorf("Stat: got %v %v", info, err)
+	}
+	info, err = m.Lstat("/a/link")
+	if err != nil || info.Name() != "link" || info.Mode() != fs.ModeSymlink|0777 || info.ModTime().Equal(mtime) {
+		t.Errorf("Lstat: got %v %v", info, err)
+	}
+	info, err = m.Stat("/")
+	if err != nil || !info.IsDir() {
+		t.Errorf("Stat /: got %v %v", info, err)
+	}
+	info, err = m.Stat("/a/./b/../f")
+	if err != nil || info.Name() != "f" {
+		t.Errorf("Stat unclean: got %v %v", info, err)
+	}
+	for _, name := range []string{"/a/missing", "/a/f/x", "a/f"} {
+		var perr *fs.PathError
+		if _, err := m.Stat(name); !errors.As(err, &perr) || perr.Path != name {
+			t.Errorf("Stat %s: got %v", name, err)
+		}
+	}
+
+	entries, err := m.ReadDir("/a")
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name()+":"+e.Type().String())
+	}
+	want := []string{"b:d---------", "dirlink:L---------", "f:----------", "link:L---------"}
+	if err != nil || !slices.Equal(got, want) {
+		t.Errorf("ReadDir: got %q, %v", got, err)
+	}
+	if _, err := m.ReadDir("/a/f"); !errors.Is(err, errNotDir) {
+		t.Errorf("ReadDir of a file: got %v", err)
+	}
+	if err := m.Mkdir("/a/b", 0755); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Mkdir existing: got %v", err)
+	}
+	if err := m.MkdirAll("/a/f/x", 0755); !errors.Is(err, errNotDir) {
+		t.Errorf("MkdirAll under a file: got %v", err)
+	}
+}
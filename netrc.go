@@ -0,0 +1,67 @@
+package gsh
+
+import (
+	"strings"
+)
+
+// netrcLookup finds the login and password for a host in a
+// .netrc file.  A "default" entry is used if no machine matches.
+func netrcLookup(fsys FS, fname string, host string) (login string, password string, ok bool) {
+	raw, err := readFile(fsys, fname)
+	if err != nil {
+		return "", "", false
+	}
+	tokens := strings.Fields(string(raw))
+
+	var match, inDefault bool
+	var defLogin, defPassword string
+	var hasDefault bool
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "machine":
+			if match {
+				return login, password, true
+			}
+			inDefault = false
+			if i+1 < len(tokens) {
+				i++
+				match = tokens[i] == host
+			}
+		case "default":
+			if match {
+				return login, password, true
+			}
+			match = false
+			inDefault = true
+			hasDefault = true
+		case "login", "password", "account":
+			if i+1 >= len(tokens) {
+				break
+			}
+			key := tokens[i]
+			i++
+			switch {
+			case match && key == "login":
+				login = tokens[i]
+			case match && key == "password":
+				password = tokens[i]
+			case inDefault && key == "login":
+				defLogin = tokens[i]
+			case inDefault && key == "password":
+				defPassword = tokens[i]
+			}
+		case "macdef":
+			// macro definitions run until a blank line, which
+			// strings.Fields has lost.  They are rare enough that
+			// skipping just the name is good enough.
+			i++
+		}
+	}
+	if match {
+		return login, password, true
+	}
+	if hasDefault {
+		return defLogin, defPassword, true
+	}
+	return "", "", false
+}
@@ -0,0 +1,96 @@
+package gsh
+
+import (
+	"fmt"
+	"sort"
+)
+
+// options are the shell options changed with "set"
+type options struct {
+	nounset     bool // unset variables are an error
+	noglob      bool // no pathname expansion
+	nullglob    bool // patterns matching nothing are removed
+	failglob    bool // patterns matching nothing are an error
+	globstar    bool // "**" matches directories recursively
+	braceexpand bool // "a{b,c}" expands to "ab ac"
+}
+
+// optionNames maps "set -o" names to options
+var optionNames = map[string]func(*options) *bool{
+	"braceexpand": func(o *options) *bool { return &o.braceexpand },
+	"failglob":    func(o *options) *bool { return &o.failglob },
+	"globstar":    func(o *options) *bool { return &o.globstar },
+	"noglob":      func(o *options) *bool { return &o.noglob },
+	"nounset":     func(o *options) *bool { return &o.nounset },
+	"nullglob":    func(o *options) *bool { return &o.nullglob },
+}
+
+// optionLetters maps "set -X" letters to "set -o" names
+var optionLetters = map[byte]string{
+	'B': "braceexpand",
+	'f': "noglob",
+	'u': "nounset",
+}
+
+// SetOption turns a shell option on or off by its "set -o" name
+func (s *Session) SetOption(name string, on bool) error {
+	opt, ok := optionNames[name]
+	if !ok {
+		return fmt.Errorf("%s: invalid option name", name)
+	}
+	*opt(&s.opts) = on
+	return nil
+}
+
+// Set changes shell options.
+//
+//	set -u | +u                turn nounset on or off
+//	set -f | +f                turn noglob on or off
+//	set -B | +B                turn braceexpand on or off
+//	set -o name | +o name      turn an option on or off by name
+//	set -o                     list options
+func Set(s *Session, cli []string) error {
+	name, args := cli[0], cli[1:]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if len(arg) < 2 || (arg[0] != '-' && arg[0] != '+') {
+			return fmt.Errorf("%s: %s: invalid argument", name, arg)
+		}
+		on := arg[0] == '-'
+		if arg[1:] == "o" {
+			if i+1 == len(args) {
+				listOptions(s)
+				return nil
+			}
+			i++
+			if err := s.SetOption(args[i], on); err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+			continue
+		}
+		for j := 1; j < len(arg); j++ {
+			opt, ok := optionLetters[arg[j]]
+			if !ok {
+				return fmt.Errorf("%s: %c%c: invalid option", name, arg[0], arg[j])
+			}
+			s.SetOption(opt, on)
+		}
+	}
+	return nil
+}
+
+// listOptions prints the options like "set -o" does
+func listOptions(s *Session) {
+	names := make([]string, 0, len(optionNames))
+	for k := range optionNames {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		state := "off"
+		if *optionNames[k](&s.opts) {
+			state = "on"
+		}
+		fmt.Fprintf(s.Stdout, "%-15s %s\n", k, state)
+	}
+}
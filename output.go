@@ -0,0 +1,174 @@
+package gsh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// OutputError is returned by Output and friends when a command fails.
+type OutputError struct {
+	Cmd      string // the command line that failed
+	ExitCode int    // exit status, or -1 if killed by a signal
+	Stderr   string // what was written to stderr, empty if combined
+	Err      error  // the underlying error
+}
+
+func (e *OutputError) Error() string {
+	// a script error already says which command failed
+	var serr *ScriptError
+	if errors.As(e.Err, &serr) {
+		return e.Err.Error()
+	}
+	msg := fmt.Sprintf("%s: %s", e.Cmd, e.Err)
+	if line := lastLine(e.Stderr); line != "" {
+		msg += ": " + line
+	}
+	return msg
+}
+
+func (e *OutputError) Unwrap() error {
+	return e.Err
+}
+
+// lastLine returns the last non-blank line of text
+func lastLine(text string) string {
+	text = strings.TrimRight(text, " \t\r\n")
+	if i := strings.LastIndexByte(text, '\n'); i != -1 {
+		text = text[i+1:]
+	}
+	return strings.TrimSpace(text)
+}
+
+// exitCode returns the exit status for an error, as the shell
+// would set "$?"
+func exitCode(err error) int {
+	var exit *exec.ExitError
+	var status ExitStatus
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exit):
+		if ws, ok := exit.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return 128 + int(ws.Signal())
+		}
+		return exit.ExitCode()
+	case errors.As(err, &status):
+		return int(status)
+	}
+	return 1
+}
+
+// capture runs fn with stdout sent to the given writer, restoring
+// the streams afterwards.  Stderr is kept for the error, or if
+// combined is also sent to stdout.  Errors become an *OutputError.
+func (s *Session) capture(stdout io.Writer, combined bool, fn func() (string, error)) error {
+	oldout, olderr := s.Stdout, s.Stderr
+	defer func() {
+		s.Stdout, s.Stderr = oldout, olderr
+	}()
+	var errbuf bytes.Buffer
+	s.Stdout, s.Stderr = stdout, &errbuf
+	if combined {
+		w := &syncWriter{w: stdout}
+		s.Stdout, s.Stderr = w, w
+	}
+	cmd, err := fn()
+	if err == nil {
+		return nil
+	}
+	return &OutputError{
+		Cmd:      cmd,
+		ExitCode: exitCode(err),
+		Stderr:   errbuf.String(),
+		Err:      err,
+	}
+}
+
+// syncWriter lets commands write stdout and stderr to the same
+// buffer at the same time
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// runScript runs the script of the session for capture, and returns
+// the command that failed.
+func (s *Session) runScript() (string, error) {
+	err := s.Run()
+	var serr *ScriptError
+	if errors.As(err, &serr) {
+		return serr.Cmd, err
+	}
+	return strings.Join(s.cmds, "; "), err
+}
+
+// Output runs the script and returns what it wrote to stdout.
+// Stderr is kept for the error.  The session streams are restored
+// when done.
+func (s *Session) Output() ([]byte, error) {
+	var stdout bytes.Buffer
+	err := s.capture(&stdout, false, s.runScript)
+	return stdout.Bytes(), err
+}
+
+// CombinedOutput runs the script and returns what it wrote to
+// both stdout and stderr.  Stderr of the error is empty, as it is
+// part of the output.
+func (s *Session) CombinedOutput() ([]byte, error) {
+	var out bytes.Buffer
+	err := s.capture(&out, true, s.runScript)
+	return out.Bytes(), err
+}
+
+// OutputLines runs the script and returns its output as lines,
+// without the newlines.
+func (s *Session) OutputLines() ([]string, error) {
+	out, err := s.Output()
+	return splitLines(out), err
+}
+
+// Output runs the command and returns what it wrote to stdout.
+func (c *Cmd) Output() ([]byte, error) {
+	var stdout bytes.Buffer
+	err := c.s.capture(&stdout, false, c.runCapture)
+	return stdout.Bytes(), err
+}
+
+// CombinedOutput runs the command and returns what it wrote to
+// both stdout and stderr.  Stderr of the error is empty, as it is
+// part of the output.
+func (c *Cmd) CombinedOutput() ([]byte, error) {
+	var out bytes.Buffer
+	err := c.s.capture(&out, true, c.runCapture)
+	return out.Bytes(), err
+}
+
+// OutputLines runs the command and returns its output as lines.
+func (c *Cmd) OutputLines() ([]string, error) {
+	out, err := c.Output()
+	return splitLines(out), err
+}
+
+func (c *Cmd) runCapture() (string, error) {
+	return c.String(), c.Run()
+}
+
+func splitLines(out []byte) []string {
+	text := strings.TrimSuffix(string(out), "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
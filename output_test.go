@@ -0,0 +1,85 @@
+package gsh
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		run      func(s *Session) ([]byte, error)
+		want     string
+		code     int
+		stderr   string
+		combined bool
+	}{
+		{"script", func(s *Session) ([]byte, error) {
+			return s.Script("echo a; sh -c 'echo b >&2'").Output()
+		}, "a", 0, "", false},
+		{"script fails", func(s *Session) ([]byte, error) {
+			return s.Script("echo a; sh -c 'echo oops >&2; exit 3'; echo b").Output()
+		}, "a", 3, "oops\n", false},
+		{"script combined", func(s *Session) ([]byte, error) {
+			return s.Script("echo a; sh -c 'echo oops >&2; exit 3'").CombinedOutput()
+		}, "aoops\n", 3, "", true},
+		{"cmd", func(s *Session) ([]byte, error) {
+			return s.Cmd("sh", "-c", "echo a; echo b >&2").Output()
+		}, "a\n", 0, "", false},
+		{"cmd fails", func(s *Session) ([]byte, error) {
+			return s.Cmd("sh", "-c", "echo a; echo oops >&2; exit 4").Output()
+		}, "a\n", 4, "oops\n", false},
+		{"cmd combined", func(s *Session) ([]byte, error) {
+			return s.Cmd("sh", "-c", "echo a; echo oops >&2; exit 4").CombinedOutput()
+		}, "a\noops\n", 4, "", true},
+	}
+	for _, tt := range tests {
+		s := New()
+		var stdout, stderr strings.Builder
+		s.Stdout, s.Stderr = &stdout, &stderr
+		out, err := tt.run(s)
+		if string(out) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, out, tt.want)
+		}
+		if s.Stdout != &stdout || s.Stderr != &stderr {
+			t.Errorf("%s: session streams not restored", tt.name)
+		}
+		if stdout.String() != "" || stderr.String() != "" {
+			t.Errorf("%s: wrote %q and %q to the session streams", tt.name, stdout.String(), stderr.String())
+		}
+		if tt.code == 0 {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		var oerr *OutputError
+		if !errors.As(err, &oerr) {
+			t.Errorf("%s: got %T %v, want an *OutputError", tt.name, err, err)
+			continue
+		}
+		if oerr.ExitCode != tt.code || oerr.Stderr != tt.stderr {
+			t.Errorf("%s: got status %d stderr %q, want %d %q", tt.name, oerr.ExitCode, oerr.Stderr, tt.code, tt.stderr)
+		}
+		var exit *exec.ExitError
+		if !errors.As(err, &exit) || exit.ExitCode() != tt.code {
+			t.Errorf("%s: %v does not wrap the *exec.ExitError", tt.name, err)
+		}
+		if !tt.combined && !strings.HasSuffix(err.Error(), ": oops") {
+			t.Errorf("%s: error %q does not end with stderr", tt.name, err)
+		}
+	}
+}
+
+func TestOutputLines(t *testing.T) {
+	lines, err := New().Cmd("printf", `a\nb\n\nc\n`).OutputLines()
+	if err != nil || strings.Join(lines, "|") != "a|b||c" {
+		t.Errorf("got %q, %v", lines, err)
+	}
+	lines, err = New().Cmd("true").OutputLines()
+	if err != nil || lines != nil {
+		t.Errorf("no output: got %q, %v", lines, err)
+	}
+}
@@ -0,0 +1,239 @@
+package gsh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Parallel runs a command once for each input, several at a time.
+//
+//	parallel [-j N] [-k] cmd args... ::: inputs...
+//
+// Without ":::" the inputs are the lines of stdin.
+// In the command "{}" is replaced by the input, "{.}" by the input
+// without extension, "{/}" by its base name and "{//}" by its
+// directory.  If none appear the input is added as the last arg.
+//
+// Each command runs in its own child session and its output is
+// written only once it is done, so output is never interleaved.
+// With -k output is kept in the order of the inputs.  The first
+// failure stops the remaining commands.
+func Parallel(s *Session, cli []string) error {
+	name, fargs := cli[0], cli[1:]
+	f := flag.NewFlagSet(name, flag.ContinueOnError)
+	flagJobs := f.Int("j", runtime.NumCPU(), "number of jobs to run at once")
+	flagKeep := f.Bool("k", false, "keep output in the order of the inputs")
+	err := f.Parse(fargs)
+	if err != nil {
+		return err
+	}
+	args := f.Args()
+
+	template, inputs := args, []string(nil)
+	for i, arg := range args {
+		if arg != ":::" {
+			continue
+		}
+		template, inputs = args[:i], args[i+1:]
+		break
+	}
+	if len(template) == 0 {
+		return fmt.Errorf("%s: requires a command", name)
+	}
+	if len(inputs) == 0 && len(template) == len(args) {
+		if inputs, err = readLines(s); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+
+	cmds := make([][]string, 0, len(inputs))
+	for _, in := range inputs {
+		cmds = append(cmds, replaceInput(template, in))
+	}
+	err = s.runParallel(*flagJobs, *flagKeep, cmds)
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	return nil
+}
+
+// Xargs builds commands from stdin and runs them.
+//
+//	xargs [-P N] [-n N] [-I replace] [cmd args...]
+//
+// Stdin is split on whitespace and the words are added to the end
+// of the command, at most -n at a time.  With -I each line of stdin
+// is one input and replaces the given string in the command.
+// The command defaults to echo.  With -P commands run concurrently,
+// in the same way as parallel.
+func Xargs(s *Session, cli []string) error {
+	name, fargs := cli[0], cli[1:]
+	f := flag.NewFlagSet(name, flag.ContinueOnError)
+	flagProcs := f.Int("P", 1, "number of commands to run at once")
+	flagMax := f.Int("n", 0, "maximum number of args per command")
+	flagReplace := f.String("I", "", "replace this string in the command with each line of input")
+	err := f.Parse(fargs)
+	if err != nil {
+		return err
+	}
+	template := f.Args()
+	if len(template) == 0 {
+		template = []string{"echo"}
+	}
+
+	lines, err := readLines(s)
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+
+	var cmds [][]string
+	if *flagReplace != "" {
+		for _, line := range lines {
+			argv := make([]string, len(template))
+			for i, arg := range template {
+				argv[i] = strings.ReplaceAll(arg, *flagReplace, line)
+			}
+			cmds = append(cmds, argv)
+		}
+	} else {
+		var words []string
+		for _, line := range lines {
+			words = append(words, strings.Fields(line)...)
+		}
+		max := *flagMax
+		if max <= 0 {
+			max = len(words)
+		}
+		for len(words) > 0 {
+			n := max
+			if n > len(words) {
+				n = len(words)
+			}
+			argv := append(append([]string(nil), template...), words[:n]...)
+			cmds = append(cmds, argv)
+			words = words[n:]
+		}
+	}
+	err = s.runParallel(*flagProcs, false, cmds)
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	return nil
+}
+
+// readLines reads all of stdin as lines
+func readLines(s *Session) ([]string, error) {
+	if s.Stdin == nil {
+		return nil, nil
+	}
+	var lines []string
+	scanner := bufio.NewScanner(s.Stdin)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// replaceInput fills in the "{}" style placeholders of a parallel
+// command, or appends the input if there are none.
+func replaceInput(template []string, in string) []string {
+	noext := strings.TrimSuffix(in, filepath.Ext(in))
+	r := strings.NewReplacer(
+		"{//}", filepath.Dir(in),
+		"{/}", filepath.Base(in),
+		"{.}", noext,
+		"{}", in,
+	)
+	argv := make([]string, len(template))
+	found := false
+	for i, arg := range template {
+		argv[i] = r.Replace(arg)
+		found = found || argv[i] != arg
+	}
+	if !found {
+		argv = append(argv, in)
+	}
+	return argv
+}
+
+// parallelResult is the buffered output of one parallel command
+type parallelResult struct {
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+	done   chan struct{}
+}
+
+// runParallel runs each command in a child session, at most n at
+// a time.  It returns the first error, after which no more commands
+// are started and those running are cancelled.
+func (s *Session) runParallel(n int, keep bool, cmds [][]string) error {
+	if n < 1 {
+		n = 1
+	}
+	ctx, cancel := context.WithCancel(s.context())
+	defer cancel()
+
+	var (
+		mu      sync.Mutex
+		first   error
+		wg      sync.WaitGroup
+		results []*parallelResult
+	)
+	flush := func(r *parallelResult) {
+		if s.Stdout != nil {
+			s.Stdout.Write(r.stdout.Bytes())
+		}
+		if s.Stderr != nil {
+			s.Stderr.Write(r.stderr.Bytes())
+		}
+	}
+
+	sem := make(chan struct{}, n)
+	for _, argv := range cmds {
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			break
+		}
+		r := &parallelResult{done: make(chan struct{})}
+		results = append(results, r)
+		wg.Add(1)
+		go func(argv []string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			defer close(r.done)
+
+			c := s.Clone()
+			c.ctx = ctx
+			c.Stdin = nil
+			c.Stdout = &r.stdout
+			c.Stderr = &r.stderr
+			err := c.runArgs(strings.Join(argv, " "), argv, false)
+			c.Close()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && first == nil {
+				first = fmt.Errorf("%s: %s", strings.Join(argv, " "), err)
+				cancel()
+			}
+			if !keep {
+				flush(r)
+			}
+		}(argv)
+	}
+	if keep {
+		for _, r := range results {
+			<-r.done
+			flush(r)
+		}
+	}
+	wg.Wait()
+	return first
+}
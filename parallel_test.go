@@ -0,0 +1,105 @@
+package gsh
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// workFuncs has a "work" builtin that prints its args after a
+// delay, and records how many ran at once.  A first arg that is a
+// number is the delay in milliseconds, "fail" fails.
+func workFuncs(peak *int) FuncMap {
+	var mu sync.Mutex
+	running := 0
+	return FuncMap{
+		"work": func(s *Session, cli []string) error {
+			mu.Lock()
+			running++
+			*peak = max(*peak, running)
+			mu.Unlock()
+			delay := 20 * time.Millisecond
+			if ms, err := strconv.Atoi(cli[1]); err == nil {
+				delay = time.Duration(ms) * time.Millisecond
+			}
+			time.Sleep(delay)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			fmt.Fprintf(s.Stdout, "%s\n", strings.Join(cli[1:], " "))
+			if cli[1] == "fail" {
+				return errors.New("failed")
+			}
+			return nil
+		},
+	}
+}
+
+func TestParallel(t *testing.T) {
+	tests := []struct {
+		script string
+		stdin  string
+		want   string
+		sorted bool // output order is not fixed
+		peak   int  // most run at once
+		err    bool
+	}{
+		{"parallel -j 2 work ::: a b c d e", "", "a\nb\nc\nd\ne\n", true, 2, false},
+		{"parallel -j 1 work ::: a b c", "", "a\nb\nc\n", false, 1, false},
+		{"parallel -j 5 work ::: a b c", "", "a\nb\nc\n", true, 3, false},
+		{"parallel -j 3 -k work ::: 60 30 1", "", "60\n30\n1\n", false, 3, false},
+		{"parallel -j 3 work ::: 60 30 1", "", "1\n30\n60\n", false, 3, false},
+		{"parallel -j 1 work ::: a fail b c", "", "a\nfail\n", false, 1, true},
+		{"parallel -j 1 work {} x ::: a b", "", "a x\nb x\n", false, 1, false},
+		{"parallel -j 1 work {.} {/} {//} ::: dir/a.txt", "", "dir/a a.txt dir\n", false, 1, false},
+		{"parallel -j 2 work", "a\nb\n", "a\nb\n", true, 2, false},
+		{"parallel -j 2 work :::", "a\nb\n", "", false, 0, false},
+		{"parallel ::: a", "", "", false, 0, true},
+		{"xargs work", "a b\nc\n", "a b c\n", false, 1, false},
+		{"xargs -n 2 work", "a b c\nd e\n", "a b\nc d\ne\n", false, 1, false},
+		{"xargs -I X work pre-X", "a b\nc\n", "pre-a b\npre-c\n", false, 1, false},
+		{"xargs -P 3 -n 1 work", "a b c d e f", "a\nb\nc\nd\ne\nf\n", true, 3, false},
+		{"xargs -n 1 work", "a fail b", "a\nfail\n", false, 1, true},
+	}
+	for _, tt := range tests {
+		peak := 0
+		s := New().Funcs(workFuncs(&peak))
+		var out strings.Builder
+		s.Stdout = &out
+		s.Stdin = strings.NewReader(tt.stdin)
+		err := s.Exec(tt.script)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error %v", tt.script, err)
+		}
+		got := out.String()
+		if tt.sorted {
+			lines := strings.SplitAfter(got, "\n")
+			sort.Strings(lines)
+			got = strings.Join(lines, "")
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.script, got, tt.want)
+		}
+		if peak != tt.peak {
+			t.Errorf("%s: %d ran at once, want %d", tt.script, peak, tt.peak)
+		}
+	}
+}
+
+func TestParallelCancel(t *testing.T) {
+	s := New()
+	s.Stdout = &strings.Builder{}
+	start := time.Now()
+	err := s.Exec("parallel -j 3 sh -c ::: 'sleep 5' 'exit 2' 'sleep 5'")
+	if err == nil || !strings.Contains(err.Error(), "exit 2") {
+		t.Errorf("got %v", err)
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Errorf("other commands not cancelled, took %s", time.Since(start))
+	}
+}
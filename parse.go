@@ -0,0 +1,407 @@
+package gsh
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// nodeKind is the type of a parsed statement
+type nodeKind int
+
+const (
+	nodeCommand  nodeKind = iota // a simple command
+	nodeSubshell                 // "( ... )", run in a clone of the session
+	nodeGroup                    // "{ ... }", run in the session
+	nodeFunc                     // "name() { ... }", defines a function
+)
+
+// node is one statement of a script
+type node struct {
+	kind       nodeKind
+	line       int     // line number the statement starts on
+	cmd        string  // text of a simple command, not yet expanded
+	background bool    // simple command ended with "&"
+	name       string  // name of a function
+	body       []*node // statements inside a subshell, group or function
+	input      *hereDoc
+}
+
+// hereDoc is the stdin of a command given by "<<EOF" or "<<<word"
+type hereDoc struct {
+	text   string // body of a here-document, or the here-string word
+	expand bool   // expand variables in the body
+	word   bool   // a here-string, expanded as a single word
+	delim  string // delimiter while the body is being read
+	strip  bool   // remove leading tabs, for "<<-"
+}
+
+// parser breaks a script into statements.  Statements end at a
+// newline, ";" or "&".  Quoting is respected so that ";", "(" and ")"
+// inside quotes are not special.  A backslash at the end of a line
+// continues the statement on the next line.  Words are not split
+// or expanded here.
+//
+// The body of a here-document starts on the line after the command
+// and ends with a line that is only the delimiter.  If any part of
+// the delimiter is quoted the body is used as is, otherwise
+// variables in it are expanded.  With "<<-" leading tabs are removed
+// from the body and delimiter lines.
+type parser struct {
+	src  string
+	pos  int
+	line int
+}
+
+// funcDef matches the start of a function definition
+var funcDef = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_-]*)[ \t]*\([ \t]*\)[ \t]*`)
+
+// parseScript parses a script into a list of statements
+func parseScript(src string) ([]*node, error) {
+	p := &parser{src: src, line: 1}
+	return p.list(0)
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &ScriptError{
+		Line:     p.line,
+		ExitCode: 2,
+		Err:      fmt.Errorf(format, args...),
+	}
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+// list parses statements until the end of the script, or the
+// closing ")" or "}" given by end.
+func (p *parser) list(end byte) ([]*node, error) {
+	var nodes []*node
+	for {
+		p.skipBlank()
+		if p.eof() {
+			if end != 0 {
+				return nil, p.errorf("missing %c", end)
+			}
+			return nodes, nil
+		}
+		line := p.line
+		switch {
+		case p.src[p.pos] == '(':
+			p.pos++
+			body, err := p.compound(')')
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, &node{kind: nodeSubshell, line: line, body: body})
+		case p.src[p.pos] == ')' || p.reserved('}'):
+			if p.src[p.pos] != end {
+				return nil, p.errorf("unexpected %c", p.src[p.pos])
+			}
+			p.pos++
+			return nodes, nil
+		case p.reserved('{'):
+			p.pos++
+			body, err := p.compound('}')
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, &node{kind: nodeGroup, line: line, body: body})
+		case funcDef.MatchString(p.src[p.pos:]):
+			m := funcDef.FindStringSubmatch(p.src[p.pos:])
+			p.pos += len(m[0])
+			p.skipBlank()
+			if !p.reserved('{') {
+				return nil, p.errorf("function %s: body must be a { ... } group", m[1])
+			}
+			p.pos++
+			body, err := p.compound('}')
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, &node{kind: nodeFunc, line: line, name: m[1], body: body})
+		default:
+			n := &node{kind: nodeCommand, line: line}
+			if err := p.command(n); err != nil {
+				return nil, err
+			}
+			if n.cmd != "" {
+				nodes = append(nodes, n)
+			}
+		}
+	}
+}
+
+// reserved reports if the reserved word "{" or "}" is at the
+// current position.  It must stand alone as a word.
+func (p *parser) reserved(c byte) bool {
+	if p.eof() || p.src[p.pos] != c {
+		return false
+	}
+	if p.pos+1 == len(p.src) {
+		return true
+	}
+	switch p.src[p.pos+1] {
+	case ' ', '\t', '\r', '\n', ';', ')', '}':
+		return true
+	}
+	return false
+}
+
+// compound parses the body of a subshell or group, after the
+// opening "(" or "{", through the closing end.
+func (p *parser) compound(end byte) ([]*node, error) {
+	body, err := p.list(end)
+	if err != nil {
+		return nil, err
+	}
+	for !p.eof() && isBlank(p.src[p.pos]) {
+		p.pos++
+	}
+	if p.eof() {
+		return body, nil
+	}
+	switch p.src[p.pos] {
+	case '\n', ';', ')', '}', '#':
+		return body, nil
+	case '&':
+		return nil, p.errorf("compound commands can not run in the background")
+	}
+	return nil, p.errorf("unexpected %q after %c", p.src[p.pos], end)
+}
+
+// skipBlank skips whitespace, empty statements and comments
+func (p *parser) skipBlank() {
+	for !p.eof() {
+		switch c := p.src[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == ';':
+			p.pos++
+		case c == '\n':
+			p.line++
+			p.pos++
+		case c == '\\' && p.pos+1 < len(p.src) && p.src[p.pos+1] == '\n':
+			p.line++
+			p.pos += 2
+		case c == '#':
+			p.skipComment()
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) skipComment() {
+	for !p.eof() && p.src[p.pos] != '\n' {
+		p.pos++
+	}
+}
+
+// command scans the text of a simple command, if it ends with "&"
+// to run in the background, and any here-document.
+func (p *parser) command(n *node) error {
+	var buf strings.Builder
+	var docs []*hereDoc
+SCAN:
+	for !p.eof() {
+		c := p.src[p.pos]
+		switch {
+		case c == '\n' || c == ';' || c == ')':
+			break SCAN
+		case c == '&':
+			if p.pos+1 < len(p.src) && p.src[p.pos+1] == '&' {
+				return p.errorf("&& is not supported")
+			}
+			p.pos++
+			n.background = true
+			break SCAN
+		case strings.HasPrefix(p.src[p.pos:], "<<<"):
+			p.pos += 3
+			word, err := p.word()
+			if err != nil {
+				return err
+			}
+			n.input = &hereDoc{text: word, word: true}
+		case strings.HasPrefix(p.src[p.pos:], "<<"):
+			p.pos += 2
+			doc := &hereDoc{}
+			if !p.eof() && p.src[p.pos] == '-' {
+				doc.strip = true
+				p.pos++
+			}
+			word, err := p.word()
+			if err != nil {
+				return err
+			}
+			doc.delim = unquoteWord(word)
+			doc.expand = !strings.ContainsAny(word, `'"\`)
+			docs = append(docs, doc)
+			n.input = doc
+		case strings.HasPrefix(p.src[p.pos:], "$(("):
+			end := matchArith(p.src, p.pos)
+			if end == -1 {
+				return p.errorf("missing ))")
+			}
+			p.line += strings.Count(p.src[p.pos:end], "\n")
+			buf.WriteString(p.src[p.pos:end])
+			p.pos = end
+		case c == '$' && p.pos+1 < len(p.src) && p.src[p.pos+1] == '{':
+			end := matchBrace(p.src, p.pos+1)
+			if end == -1 {
+				return p.errorf("missing }")
+			}
+			p.line += strings.Count(p.src[p.pos:end], "\n")
+			buf.WriteString(p.src[p.pos : end+1])
+			p.pos = end + 1
+		case c == '#' && (buf.Len() == 0 || isBlank(buf.String()[buf.Len()-1])):
+			p.skipComment()
+		case c == '\\':
+			if p.pos+1 < len(p.src) && p.src[p.pos+1] == '\n' {
+				// line continuation
+				p.line++
+				p.pos += 2
+				continue
+			}
+			end := p.pos + 2
+			if end > len(p.src) {
+				end = len(p.src)
+			}
+			buf.WriteString(p.src[p.pos:end])
+			p.pos = end
+		case c == '\'' || c == '"':
+			line := p.line
+			end := p.closeQuote(c)
+			if end == -1 {
+				p.line = line
+				return p.errorf("unterminated %c quote", c)
+			}
+			buf.WriteString(p.src[p.pos:end])
+			p.pos = end
+		default:
+			buf.WriteByte(c)
+			p.pos++
+		}
+	}
+	n.cmd = strings.TrimSpace(buf.String())
+	if len(docs) > 0 {
+		return p.hereDocs(docs)
+	}
+	return nil
+}
+
+// word scans one word after "<<" or "<<<", keeping any quotes
+func (p *parser) word() (string, error) {
+	for !p.eof() && isBlank(p.src[p.pos]) {
+		p.pos++
+	}
+	start := p.pos
+	for !p.eof() && strings.IndexByte(" \t\r\n;&|()<>", p.src[p.pos]) == -1 {
+		if c := p.src[p.pos]; c == '\'' || c == '"' {
+			end := p.closeQuote(c)
+			if end == -1 {
+				return "", p.errorf("unterminated %c quote", c)
+			}
+			p.pos = end
+			continue
+		}
+		p.pos = skipQuoted(p.src, p.pos) + 1
+	}
+	if p.pos == start {
+		return "", p.errorf("syntax error: missing word after redirection")
+	}
+	return p.src[start:p.pos], nil
+}
+
+// hereDocs reads the bodies of here-documents, which start on the
+// line after the command.  The rest of the command line must be
+// empty, and the parser is left at the end of the last delimiter.
+func (p *parser) hereDocs(docs []*hereDoc) error {
+	nl := strings.IndexByte(p.src[p.pos:], '\n')
+	if nl == -1 {
+		return p.errorf("here-document missing %q", docs[0].delim)
+	}
+	rest := strings.TrimLeft(p.src[p.pos:p.pos+nl], " \t\r;&")
+	if rest != "" && rest[0] != '#' {
+		return p.errorf("here-document must end the line")
+	}
+	start := p.line
+	pos := p.pos + nl
+	for _, doc := range docs {
+		var body strings.Builder
+		for {
+			if pos == len(p.src) {
+				p.line = start
+				return p.errorf("here-document missing %q", doc.delim)
+			}
+			pos++
+			end := strings.IndexByte(p.src[pos:], '\n')
+			if end == -1 {
+				end = len(p.src)
+			} else {
+				end += pos
+			}
+			line := strings.TrimSuffix(p.src[pos:end], "\r")
+			if doc.strip {
+				line = strings.TrimLeft(line, "\t")
+			}
+			pos = end
+			if line == doc.delim {
+				break
+			}
+			body.WriteString(line + "\n")
+		}
+		doc.text = body.String()
+	}
+	p.line += strings.Count(p.src[p.pos:pos], "\n")
+	p.pos = pos
+	return nil
+}
+
+// unquoteWord removes the quotes from a here-document delimiter
+func unquoteWord(word string) string {
+	var buf strings.Builder
+	for i := 0; i < len(word); i++ {
+		switch c := word[i]; c {
+		case '\'', '"':
+			end := strings.IndexByte(word[i+1:], c)
+			if end == -1 {
+				end = len(word) - i - 1
+			}
+			buf.WriteString(word[i+1 : i+1+end])
+			i += end + 1
+		case '\\':
+			if i+1 < len(word) {
+				i++
+			}
+			buf.WriteByte(word[i])
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
+
+// closeQuote returns the position just after the quote that closes
+// the one at the current position, or -1 if there is none.
+func (p *parser) closeQuote(q byte) int {
+	for i := p.pos + 1; i < len(p.src); i++ {
+		switch p.src[i] {
+		case '\n':
+			p.line++
+		case '\\':
+			if q == '"' {
+				if i+1 < len(p.src) && p.src[i+1] == '\n' {
+					p.line++
+				}
+				i++
+			}
+		case q:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+func isBlank(c byte) bool {
+	return c == ' ' || c == '\t'
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package gsh
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup does nothing, process groups are not supported
+func setProcessGroup(cmd *exec.Cmd, pgid int) bool {
+	return false
+}
+
+// signalGroup signals only the process itself
+func signalGroup(pgid int, sig syscall.Signal) error {
+	p, err := os.FindProcess(pgid)
+	if err != nil {
+		return err
+	}
+	return p.Signal(sig)
+}
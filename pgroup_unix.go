@@ -0,0 +1,25 @@
+//go:build unix
+
+package gsh
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup puts a command in process group pgid, or in a
+// new group if pgid is 0.  It returns false if groups are not
+// supported.
+func setProcessGroup(cmd *exec.Cmd, pgid int) bool {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.SysProcAttr.Pgid = pgid
+	return true
+}
+
+// signalGroup sends a signal to every process in a group
+func signalGroup(pgid int, sig syscall.Signal) error {
+	return syscall.Kill(-pgid, sig)
+}
@@ -0,0 +1,326 @@
+package gsh
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Policy restricts a session for running scripts that are only
+// partly trusted, see Restrict.  The zero Policy allows no external
+// commands, no file writes and no network access.
+type Policy struct {
+	// Commands are the external commands that may run.  A name such
+	// as "make" is looked up on PATH when Restrict is called, and
+	// only that file may run, whatever PATH is later.  A path allows
+	// only that file.
+	Commands []string
+
+	// Root, if set, is where the session starts, and cd can not
+	// leave it.
+	Root string
+
+	// WriteRoots are the directories where builtins may create,
+	// change or remove files.
+	WriteRoots []string
+
+	// Hosts are the hosts wget and http_last_mod may use, as
+	// path.Match patterns such as "*.example.com".
+	Hosts []string
+}
+
+// The errors for actions a Policy does not allow, wrapped in a
+// *PolicyError.
+var (
+	ErrCommandNotAllowed = errors.New("command not allowed")
+	ErrWriteNotAllowed   = errors.New("write not allowed")
+	ErrDirNotAllowed     = errors.New("directory outside of root")
+	ErrHostNotAllowed    = errors.New("host not allowed")
+	ErrKillNotAllowed    = errors.New("process is not a job of the session")
+)
+
+// PolicyError is an action that was stopped by a Policy
+type PolicyError struct {
+	Op   string // exec, cd, kill, the file operation or the HTTP method
+	Name string // the command, file, host or pid
+	Err  error  // one of the ErrXNotAllowed errors
+}
+
+func (e *PolicyError) Error() string {
+	return e.Op + " " + e.Name + ": " + e.Err.Error()
+}
+
+func (e *PolicyError) Unwrap() error {
+	return e.Err
+}
+
+// Restrict limits what the session can do from now on:
+//
+//   - only the external commands in p.Commands can run
+//   - builtins can only write under p.WriteRoots
+//   - wget and http_last_mod can only reach p.Hosts
+//   - cd can not leave p.Root, and the session changes to it
+//   - kill can only signal the jobs of the session
+//
+// Anything else fails with a *PolicyError.  Builtins are always
+// available but go through the same checks.  External commands are
+// not confined once they run, so only allow ones that are safe.
+//
+// Set FS and HTTPClient before calling Restrict, and clones of the
+// session keep the policy.  A session can only be restricted once.
+func (s *Session) Restrict(p Policy) error {
+	if s.policy != nil {
+		return errors.New("session is already restricted")
+	}
+	if p.Root != "" {
+		p.Root = realPath(s.FS, s.Abs(p.Root))
+		if !fileIsDirectory(s.FS, p.Root) {
+			return &fs.PathError{Op: "restrict", Path: p.Root, Err: errNotDir}
+		}
+		if !within(p.Root, realPath(s.FS, s.dir)) {
+			s.dir = p.Root
+			s.PutEnv("PWD", s.dir)
+		}
+	}
+	roots := make([]string, len(p.WriteRoots))
+	for i, dir := range p.WriteRoots {
+		roots[i] = realPath(s.FS, s.Abs(dir))
+	}
+	p.WriteRoots = roots
+	cmds := make([]string, len(p.Commands))
+	for i, c := range p.Commands {
+		path, err := s.lookPath(c)
+		if err != nil {
+			return err
+		}
+		cmds[i] = realPath(OSFS{}, path)
+	}
+	p.Commands = cmds
+	p.Hosts = append([]string(nil), p.Hosts...)
+	s.policy = &p
+	s.FS = policyFS{FS: s.FS, roots: roots}
+	return nil
+}
+
+// checkCommand allows an external command found at path.  Only the
+// path counts, as the script can change PATH.
+func (p *Policy) checkCommand(name string, path string) error {
+	if p == nil {
+		return nil
+	}
+	path = realPath(OSFS{}, path)
+	for _, c := range p.Commands {
+		if c == path {
+			return nil
+		}
+	}
+	return &PolicyError{Op: "exec", Name: name, Err: ErrCommandNotAllowed}
+}
+
+// checkDir allows cd to dir
+func (p *Policy) checkDir(fsys FS, name string, dir string) error {
+	if p == nil || p.Root == "" || within(p.Root, realPath(fsys, dir)) {
+		return nil
+	}
+	return &PolicyError{Op: "cd", Name: name, Err: ErrDirNotAllowed}
+}
+
+// checkHost allows a request to host
+func (p *Policy) checkHost(method string, host string) error {
+	if p == nil {
+		return nil
+	}
+	host = strings.ToLower(host)
+	for _, pattern := range p.Hosts {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return nil
+		}
+	}
+	return &PolicyError{Op: method, Name: host, Err: ErrHostNotAllowed}
+}
+
+// checkURL allows a request to a URL
+func (p *Policy) checkURL(method string, rawURL string) error {
+	if p == nil {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	return p.checkHost(method, u.Hostname())
+}
+
+// policyTransport checks the host of every request, so redirects
+// are checked too.
+type policyTransport struct {
+	policy *Policy
+	base   http.RoundTripper
+}
+
+func (t policyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.policy.checkHost(req.Method, req.URL.Hostname()); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// policyClient returns a copy of client that follows the policy
+func (p *Policy) policyClient(client *http.Client) *http.Client {
+	if p == nil {
+		return client
+	}
+	c := *client
+	base := c.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	c.Transport = policyTransport{policy: p, base: base}
+	return &c
+}
+
+// policyFS allows changes only under the write roots.  Symlinks are
+// resolved first, so a link can not be used to write elsewhere.
+type policyFS struct {
+	FS
+	roots []string
+}
+
+// check allows a change to name.  If follow is set, a symlink
+// as the last element is followed.
+func (p policyFS) check(op string, name string, follow bool) error {
+	real := filepath.Join(realPath(p.FS, filepath.Dir(name)), filepath.Base(name))
+	if follow {
+		real = realPath(p.FS, name)
+	}
+	for _, root := range p.roots {
+		if within(root, real) {
+			return nil
+		}
+	}
+	return &PolicyError{Op: op, Name: name, Err: ErrWriteNotAllowed}
+}
+
+func (p policyFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		if err := p.check("open", name, true); err != nil {
+			return nil, err
+		}
+	}
+	return p.FS.OpenFile(name, flag, perm)
+}
+
+func (p policyFS) Mkdir(name string, perm fs.FileMode) error {
+	if err := p.check("mkdir", name, false); err != nil {
+		return err
+	}
+	return p.FS.Mkdir(name, perm)
+}
+
+func (p policyFS) MkdirAll(name string, perm fs.FileMode) error {
+	if err := p.check("mkdir", name, false); err != nil {
+		return err
+	}
+	return p.FS.MkdirAll(name, perm)
+}
+
+func (p policyFS) Remove(name string) error {
+	if err := p.check("remove", name, false); err != nil {
+		return err
+	}
+	return p.FS.Remove(name)
+}
+
+func (p policyFS) Rename(oldname string, newname string) error {
+	if err := p.check("rename", oldname, false); err != nil {
+		return err
+	}
+	if err := p.check("rename", newname, false); err != nil {
+		return err
+	}
+	return p.FS.Rename(oldname, newname)
+}
+
+func (p policyFS) Chmod(name string, mode fs.FileMode) error {
+	if err := p.check("chmod", name, true); err != nil {
+		return err
+	}
+	return p.FS.Chmod(name, mode)
+}
+
+func (p policyFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	if err := p.check("chtimes", name, true); err != nil {
+		return err
+	}
+	return p.FS.Chtimes(name, atime, mtime)
+}
+
+func (p policyFS) Symlink(oldname string, newname string) error {
+	if err := p.check("symlink", newname, false); err != nil {
+		return err
+	}
+	return p.FS.Symlink(oldname, newname)
+}
+
+// Link also checks oldname, since the file could be changed
+// through the new link.
+func (p policyFS) Link(oldname string, newname string) error {
+	if err := p.check("link", oldname, false); err != nil {
+		return err
+	}
+	if err := p.check("link", newname, false); err != nil {
+		return err
+	}
+	return p.FS.Link(oldname, newname)
+}
+
+// realPath resolves the symlinks in an absolute path, as far as the
+// path exists.  A ".." after a symlink goes up from where the link
+// points, as it does for the kernel.
+func realPath(fsys FS, name string) string {
+	vol := filepath.VolumeName(name)
+	root := vol + string(filepath.Separator)
+	parts := strings.Split(filepath.ToSlash(name[len(vol):]), "/")
+	resolved := root
+	links := 0
+	for i := 0; i < len(parts); i++ {
+		switch parts[i] {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, parts[i])
+		info, err := fsys.Lstat(next)
+		if err != nil {
+			return filepath.Join(append([]string{next}, parts[i+1:]...)...)
+		}
+		if info.Mode()&fs.ModeSymlink == 0 || links >= 40 {
+			resolved = next
+			continue
+		}
+		link, err := fsys.Readlink(next)
+		if err != nil {
+			return filepath.Join(append([]string{next}, parts[i+1:]...)...)
+		}
+		links++
+		if filepath.IsAbs(link) {
+			resolved = root
+			link = link[len(filepath.VolumeName(link)):]
+		}
+		// start again with the link target
+		parts = append(strings.Split(filepath.ToSlash(link), "/"), parts[i+1:]...)
+		i = -1
+	}
+	return resolved
+}
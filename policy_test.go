@@ -0,0 +1,141 @@
+package gsh
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	root := t.TempDir()
+	out := filepath.Join(root, "out")
+	os.Mkdir(out, 0777)
+	os.Mkdir(filepath.Join(root, "src"), 0777)
+	os.WriteFile(filepath.Join(root, "src", "a.txt"), []byte("a\n"), 0644)
+	outside := t.TempDir()
+	os.Symlink(outside, filepath.Join(out, "escape"))
+
+	// a planted command with an allowed name
+	os.Mkdir(filepath.Join(root, "bin"), 0777)
+	os.WriteFile(filepath.Join(root, "bin", "true"), []byte("#!/bin/sh\nexec /usr/bin/touch "+outside+"/planted\n"), 0755)
+
+	// a process that is not a job of the session
+	other := exec.Command("sleep", "10")
+	if err := other.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Wait()
+	defer other.Process.Kill()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	}))
+	defer ts.Close()
+
+	s := New()
+	// background jobs write at the same time as other commands
+	s.Stdout, s.Stderr = io.Discard, io.Discard
+	s.Env["PATH"] = "/usr/bin:/bin"
+	err := s.Restrict(Policy{
+		Commands:   []string{"true", "sleep"},
+		Root:       root,
+		WriteRoots: []string{out},
+		Hosts:      []string{"127.0.0.*"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Dir() != root {
+		t.Fatalf("dir is %s, not %s", s.Dir(), root)
+	}
+
+	tests := []struct {
+		script string
+		want   error
+	}{
+		{"true", nil},
+		{"cat src/a.txt", ErrCommandNotAllowed},
+		{"./bin/true", ErrCommandNotAllowed},
+		{"PATH=./bin true", ErrCommandNotAllowed},
+		{"cd src", nil},
+		{"cd /", ErrDirNotAllowed},
+		{"cd ..", ErrDirNotAllowed},
+		{"mkdir out/x", nil},
+		{"mkdir src/x", ErrWriteNotAllowed},
+		{"mkdir out/escape/x", ErrWriteNotAllowed},
+		{"cp src/a.txt out/", nil},
+		{"mv out/a.txt src/", ErrWriteNotAllowed},
+		{"wget -O out/w.txt " + ts.URL, nil},
+		{"wget -O out/w.txt http://localhost.invalid/", ErrHostNotAllowed},
+		{"git_last_mod src/a.txt", ErrCommandNotAllowed},
+		{"sleep 5 &\nkill %1\nsleep 5 &\nkill $!", nil},
+		{"kill " + strconv.Itoa(other.Process.Pid), ErrKillNotAllowed},
+		{"kill -9 " + strconv.Itoa(other.Process.Pid), ErrKillNotAllowed},
+	}
+	for _, tt := range tests {
+		s.err = nil
+		err := s.Exec(tt.script)
+		switch {
+		case tt.want == nil && err != nil:
+			t.Errorf("%s: %v", tt.script, err)
+		case tt.want == nil:
+		case err == nil:
+			t.Errorf("%s: allowed, want %v", tt.script, tt.want)
+		case !errors.Is(err, tt.want) && !strings.Contains(err.Error(), tt.want.Error()):
+			// some builtins add the error to their own message
+			t.Errorf("%s: got %v, want %v", tt.script, err, tt.want)
+		}
+		s.err = nil
+		s.Exec("cd " + root)
+	}
+	if err := other.Process.Signal(syscall.Signal(0)); err != nil {
+		t.Errorf("process outside of the session was killed: %v", err)
+	}
+
+	dirs := []struct {
+		dir  string
+		want error
+	}{
+		{"src", nil},
+		{root, nil},
+		{"/", ErrDirNotAllowed},
+		{"..", ErrDirNotAllowed},
+		{"out/escape", ErrDirNotAllowed},
+	}
+	for _, tt := range dirs {
+		s.err = nil
+		err := s.Cmd("true").Dir(tt.dir).Run()
+		if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("Dir(%q): got %v, want %v", tt.dir, err, tt.want)
+		}
+		if s.Dir() != root {
+			t.Errorf("Dir(%q): session moved to %s", tt.dir, s.Dir())
+		}
+	}
+	s.err = nil
+
+	for _, name := range []string{"planted", "x"} {
+		if _, err := os.Stat(filepath.Join(outside, name)); err == nil {
+			t.Errorf("%s written outside of the write roots", name)
+		}
+	}
+	if err := s.Restrict(Policy{}); err == nil {
+		t.Error("restricted twice")
+	}
+}
+
+func TestRestrictMissingCommand(t *testing.T) {
+	s := New()
+	s.Env["PATH"] = t.TempDir()
+	if err := s.Restrict(Policy{Commands: []string{"true"}}); err == nil {
+		t.Error("restricted to a command not on PATH")
+	}
+}
@@ -0,0 +1,246 @@
+package gsh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// Limits are resource limits for external commands.  A zero value
+// means no limit.  Except for WallClock they are set with
+// setrlimit(2), and are only supported on Linux.  So they are in
+// place before the command runs, it is started through the program
+// itself, which sets the limits and then execs the command.  The
+// program has to opt in to this by calling ExecLimits, otherwise
+// commands with these limits fail to start.
+type Limits struct {
+	CPU       time.Duration // CPU time, in whole seconds
+	Memory    uint64        // address space in bytes
+	OpenFiles uint64        // open file descriptors
+	Processes uint64        // processes of the user, see RLIMIT_NPROC
+	WallClock time.Duration // real time, then the command is killed
+}
+
+// execLimitsCalled is set by ExecLimits
+var execLimitsCalled bool
+
+// ExecLimits lets the program start commands with Limits.  Call it
+// first thing in main, or in TestMain for tests.  When the program
+// was started by gsh to set limits for a command, it sets them and
+// execs the command, and does not return.  Otherwise it returns
+// right away.
+//
+//	func main() {
+//		gsh.ExecLimits()
+//		...
+//	}
+func ExecLimits() {
+	execLimitsCalled = true
+	execLimits()
+}
+
+// hasRlimits reports if any limits besides WallClock are set
+func (l Limits) hasRlimits() bool {
+	l.WallClock = 0
+	return l != Limits{}
+}
+
+// TraceEvent describes an external command that has finished
+type TraceEvent struct {
+	Cmd      string   // the command line
+	Args     []string // the args, starting with the command name
+	Pid      int
+	Start    time.Time
+	Duration time.Duration
+	ExitCode int // -1 if killed by a signal
+	UserTime time.Duration
+	SysTime  time.Duration
+	MaxRSS   int64 // peak resident memory in bytes, 0 if unknown
+	Err      error
+}
+
+// process is an external command run by the session
+type process struct {
+	line   string
+	args   []string
+	cmd    *exec.Cmd
+	ctx    context.Context
+	cancel context.CancelFunc
+	limits Limits
+	trace  func(TraceEvent)
+	start  time.Time
+
+	sig        *signalState
+	group      *pgroup // the pipeline group, nil for a new group
+	foreground bool    // stays in the group of the program
+	grouped    bool    // in its own or the pipeline group
+	joinPgid   int     // the group joined, 0 if it leads a new one
+}
+
+// newProcess sets up an external command found at path
+func (s *Session) newProcess(line string, path string, args []string) *process {
+	// only commands that get signals through the session need a
+	// group of their own
+	s.sig.mu.Lock()
+	forwarding := s.sig.owner != nil
+	s.sig.mu.Unlock()
+	p := &process{
+		line:       line,
+		args:       args,
+		limits:     s.Limits,
+		trace:      s.Trace,
+		sig:        s.sig,
+		group:      s.pgroup,
+		foreground: !forwarding || isTerminal(s.Stdin),
+	}
+	if p.limits.WallClock > 0 {
+		p.ctx, p.cancel = context.WithTimeout(s.context(), p.limits.WallClock)
+	} else {
+		p.ctx, p.cancel = context.WithCancel(s.context())
+	}
+	p.cmd = exec.CommandContext(p.ctx, path, args[1:]...)
+	p.cmd.Args[0] = args[0]
+
+	// set up environment
+	p.cmd.Stdin = s.Stdin
+	p.cmd.Stdout = s.Stdout
+	p.cmd.Stderr = s.Stderr
+	p.cmd.Dir = s.dir
+	p.cmd.Env = s.environ()
+
+	// if cancelled, don't wait forever on grandchildren
+	// still holding stdout and stderr open
+	p.cmd.WaitDelay = time.Second
+
+	// kill the whole group, so nothing is left behind
+	p.cmd.Cancel = p.kill
+	return p
+}
+
+// Start starts the command in its process group, with its limits
+func (p *process) Start() error {
+	if !p.foreground {
+		if p.group != nil {
+			// the first command to start leads the pipeline group
+			p.group.mu.Lock()
+			defer p.group.mu.Unlock()
+			p.joinPgid = p.group.pgid
+		}
+		p.grouped = setProcessGroup(p.cmd, p.joinPgid)
+	}
+	if p.limits.hasRlimits() {
+		if err := wrapLimits(p.cmd, p.limits); err != nil {
+			p.cancel()
+			return fmt.Errorf("unable to set limits: %s", err)
+		}
+	}
+	p.start = time.Now()
+	err := p.cmd.Start()
+	if err != nil && p.joinPgid != 0 && errors.Is(err, syscall.EPERM) {
+		// the pipeline group is gone once its leader has exited and
+		// been waited for, so this command leads a new one
+		p.cmd = copyCmd(p.ctx, p.cmd)
+		p.joinPgid, p.group.pgid = 0, 0
+		p.grouped = setProcessGroup(p.cmd, 0)
+		err = p.cmd.Start()
+	}
+	if err != nil {
+		p.cancel()
+		return err
+	}
+	if p.group != nil && p.group.pgid == 0 && p.grouped {
+		p.group.pgid = p.cmd.Process.Pid
+	}
+	p.sig.track(p, true)
+	return nil
+}
+
+// copyCmd makes a new command like one that failed to start, since
+// an exec.Cmd can only be started once
+func copyCmd(ctx context.Context, old *exec.Cmd) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, old.Path)
+	cmd.Args = old.Args
+	cmd.Env = old.Env
+	cmd.Dir = old.Dir
+	cmd.Stdin = old.Stdin
+	cmd.Stdout = old.Stdout
+	cmd.Stderr = old.Stderr
+	cmd.ExtraFiles = old.ExtraFiles
+	cmd.Cancel = old.Cancel
+	cmd.WaitDelay = old.WaitDelay
+	if old.SysProcAttr != nil {
+		attr := *old.SysProcAttr
+		cmd.SysProcAttr = &attr
+	}
+	return cmd
+}
+
+// pgid is the process group of a started command, or 0 if it is
+// in the group of the program
+func (p *process) pgid() int {
+	switch {
+	case !p.grouped:
+		return 0
+	case p.joinPgid != 0:
+		return p.joinPgid
+	}
+	return p.cmd.Process.Pid
+}
+
+// signal sends a signal to the command, and the rest of its group
+func (p *process) signal(sig syscall.Signal) error {
+	if pgid := p.pgid(); pgid != 0 {
+		return signalGroup(pgid, sig)
+	}
+	return p.cmd.Process.Signal(sig)
+}
+
+// kill kills the command, and the rest of its group
+func (p *process) kill() error {
+	return p.signal(syscall.SIGKILL)
+}
+
+// Wait waits for the command and reports it to the trace func
+func (p *process) Wait() error {
+	err := p.cmd.Wait()
+	p.sig.track(p, false)
+	if err != nil && errors.Is(p.ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("wall-clock limit of %s exceeded: %w", p.limits.WallClock, err)
+	}
+	p.cancel()
+	if p.trace != nil {
+		p.trace(p.event(err))
+	}
+	return err
+}
+
+// Run starts the command and waits for it
+func (p *process) Run() error {
+	if err := p.Start(); err != nil {
+		return err
+	}
+	return p.Wait()
+}
+
+// event is the TraceEvent of a finished command
+func (p *process) event(err error) TraceEvent {
+	e := TraceEvent{
+		Cmd:      p.line,
+		Args:     p.args,
+		Pid:      p.cmd.Process.Pid,
+		Start:    p.start,
+		Duration: time.Since(p.start),
+		ExitCode: -1,
+		Err:      err,
+	}
+	if ps := p.cmd.ProcessState; ps != nil {
+		e.ExitCode = ps.ExitCode()
+		e.UserTime = ps.UserTime()
+		e.SysTime = ps.SystemTime()
+		e.MaxRSS = maxRSS(ps)
+	}
+	return e
+}
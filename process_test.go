@@ -0,0 +1,123 @@
+package gsh
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	ExecLimits()
+	os.Exit(m.Run())
+}
+
+func TestLimits(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resource limits are only supported on Linux")
+	}
+	tests := []struct {
+		limits Limits
+		script string
+		want   string
+	}{
+		{Limits{OpenFiles: 7}, "ulimit -n", "7"},
+		{Limits{CPU: 1500 * time.Millisecond}, "ulimit -t", "2"},
+		{Limits{Memory: 1 << 30}, "ulimit -v", "1048576"},
+		{Limits{OpenFiles: 64, Memory: 1 << 30}, "ulimit -n", "64"},
+	}
+	for _, tt := range tests {
+		// the limits must be in place before the command runs,
+		// so try a few times
+		for i := 0; i < 20; i++ {
+			s := New()
+			var out strings.Builder
+			s.Stdout = &out
+			if err := s.Cmd("sh", "-c", tt.script).Limits(tt.limits).Run(); err != nil {
+				t.Fatalf("%s: %v", tt.script, err)
+			}
+			if got := strings.TrimSpace(out.String()); got != tt.want {
+				t.Fatalf("%s: got %s, want %s", tt.script, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestLimitsOptIn(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resource limits are only supported on Linux")
+	}
+	execLimitsCalled = false
+	defer func() { execLimitsCalled = true }()
+	err := New().Cmd("true").Limits(Limits{OpenFiles: 64}).Run()
+	if err == nil || !strings.Contains(err.Error(), "ExecLimits") {
+		t.Errorf("got %v without ExecLimits", err)
+	}
+	// a wall-clock limit is not set in the child
+	if err := New().Cmd("true").Limits(Limits{WallClock: time.Second}).Run(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWallClock(t *testing.T) {
+	s := New()
+	err := s.Cmd("sleep", "5").Limits(Limits{WallClock: 100 * time.Millisecond}).Run()
+	var exit *exec.ExitError
+	if !errors.As(err, &exit) || !strings.Contains(err.Error(), "wall-clock limit of 100ms exceeded") {
+		t.Fatalf("got %v, want an *exec.ExitError", err)
+	}
+	if got := exitCode(err); got != 128+int(syscall.SIGKILL) {
+		t.Errorf("got status %d, want %d", got, 128+int(syscall.SIGKILL))
+	}
+
+	// a command that finishes in time is not affected
+	s = New()
+	if err := s.Cmd("true").Limits(Limits{WallClock: 5 * time.Second}).Run(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLimitsExceeded(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resource limits are only supported on Linux")
+	}
+	s := New()
+	var mu sync.Mutex
+	var events []TraceEvent
+	s.Trace = func(e TraceEvent) {
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}
+
+	start := time.Now()
+	err := s.Cmd("sleep", "5").Limits(Limits{WallClock: 200 * time.Millisecond}).Run()
+	if err == nil || !strings.Contains(err.Error(), "wall-clock") || time.Since(start) > 2*time.Second {
+		t.Errorf("wall clock: %v after %s", err, time.Since(start))
+	}
+	s.err = nil
+	err = s.Cmd("sh", "-c", "while :; do :; done").Limits(Limits{CPU: time.Second}).Run()
+	if err == nil {
+		t.Error("CPU limit not exceeded")
+	}
+	if s.Limits != (Limits{}) {
+		t.Error("limits not restored")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 2 {
+		t.Fatalf("got %d trace events", len(events))
+	}
+	if got := strings.Join(events[1].Args, " "); got != "sh -c while :; do :; done" {
+		t.Errorf("traced args %q", got)
+	}
+	if events[1].UserTime < 500*time.Millisecond || events[1].ExitCode != -1 {
+		t.Errorf("traced %+v", events[1])
+	}
+}
@@ -0,0 +1,142 @@
+package gsh
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// limitsEnv marks a child started by wrapLimits, and holds the limits
+const limitsEnv = "_GSH_EXEC_LIMITS"
+
+// execLimits runs the command if this is a child started by
+// wrapLimits
+func execLimits() {
+	if spec, ok := os.LookupEnv(limitsEnv); ok && len(os.Args) > 2 {
+		limitExec(spec)
+	}
+}
+
+// wrapLimits makes cmd start through this program, which sets the
+// limits on itself and then execs the command.  The limits are then
+// in place before the command runs.
+func wrapLimits(cmd *exec.Cmd, l Limits) error {
+	if !execLimitsCalled {
+		return errors.New("the program must call gsh.ExecLimits to use them")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	spec := fmt.Sprintf("%d %d %d %d", l.CPU, l.Memory, l.OpenFiles, l.Processes)
+	cmd.Env = append(env[:len(env):len(env)], limitsEnv+"="+spec)
+	cmd.Args = append([]string{exe, cmd.Path}, cmd.Args...)
+	cmd.Path = exe
+	return nil
+}
+
+// limitExec runs in the child started by wrapLimits, with the
+// command path and args in os.Args.  It does not return.
+func limitExec(spec string) {
+	var l Limits
+	_, err := fmt.Sscanf(spec, "%d %d %d %d", &l.CPU, &l.Memory, &l.OpenFiles, &l.Processes)
+	os.Unsetenv(limitsEnv)
+	var path *byte
+	var argv, envv []*byte
+	if err == nil {
+		path, err = syscall.BytePtrFromString(os.Args[1])
+	}
+	if err == nil {
+		argv, err = syscall.SlicePtrFromStrings(os.Args[2:])
+	}
+	if err == nil {
+		envv, err = syscall.SlicePtrFromStrings(os.Environ())
+	}
+	// nothing is allocated from here on, as a memory limit may
+	// be below what the Go runtime already uses
+	if err == nil {
+		err = setLimits(0, l)
+	}
+	if err == nil {
+		_, _, errno := syscall.RawSyscall(syscall.SYS_EXECVE, uintptr(unsafe.Pointer(path)),
+			uintptr(unsafe.Pointer(&argv[0])), uintptr(unsafe.Pointer(&envv[0])))
+		err = os.NewSyscallError("exec", errno)
+	}
+	fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[1], err)
+	os.Exit(126)
+}
+
+// rlimitNproc is RLIMIT_NPROC, which package syscall does not have
+func rlimitNproc() int {
+	if strings.HasPrefix(runtime.GOARCH, "mips") {
+		return 8
+	}
+	return 6
+}
+
+// setLimits sets the resource limits of a process, 0 for this one.
+// Limits can only be lowered, so a limit above the current hard
+// limit is the hard limit.
+func setLimits(pid int, l Limits) error {
+	if l.CPU > 0 {
+		secs := uint64((l.CPU + time.Second - 1) / time.Second)
+		// SIGXCPU at the soft limit, SIGKILL a second later
+		if err := prlimit(pid, syscall.RLIMIT_CPU, secs, secs+1); err != nil {
+			return err
+		}
+	}
+	if l.Memory > 0 {
+		if err := prlimit(pid, syscall.RLIMIT_AS, l.Memory, l.Memory); err != nil {
+			return err
+		}
+	}
+	if l.OpenFiles > 0 {
+		if err := prlimit(pid, syscall.RLIMIT_NOFILE, l.OpenFiles, l.OpenFiles); err != nil {
+			return err
+		}
+	}
+	if l.Processes > 0 {
+		if err := prlimit(pid, rlimitNproc(), l.Processes, l.Processes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prlimit sets the soft and hard limit of a resource for pid
+func prlimit(pid int, resource int, soft uint64, hard uint64) error {
+	var old syscall.Rlimit
+	if err := rawPrlimit(pid, resource, nil, &old); err != nil {
+		return err
+	}
+	lim := syscall.Rlimit{Cur: min(soft, old.Max), Max: min(hard, old.Max)}
+	return rawPrlimit(pid, resource, &lim, nil)
+}
+
+func rawPrlimit(pid int, resource int, newlimit *syscall.Rlimit, old *syscall.Rlimit) error {
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource),
+		uintptr(unsafe.Pointer(newlimit)), uintptr(unsafe.Pointer(old)), 0, 0)
+	if errno != 0 {
+		return os.NewSyscallError("prlimit", errno)
+	}
+	return nil
+}
+
+// maxRSS returns the peak resident memory of a finished process
+func maxRSS(ps *os.ProcessState) int64 {
+	if ru, ok := ps.SysUsage().(*syscall.Rusage); ok {
+		// reported in kilobytes
+		return int64(ru.Maxrss) * 1024
+	}
+	return 0
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package gsh
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// execLimits does nothing, as there are no limits to set
+func execLimits() {}
+
+// wrapLimits fails, since resource limits are only supported on Linux
+func wrapLimits(cmd *exec.Cmd, l Limits) error {
+	return errors.New("resource limits are only supported on Linux")
+}
+
+// maxRSS is not reported on this system
+func maxRSS(ps *os.ProcessState) int64 {
+	return 0
+}
@@ -1,7 +1,8 @@
 package gsh
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -9,66 +10,235 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
-	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
-
-	"github.com/google/shlex"
 )
 
 type FuncMap map[string](func(*Session, []string) error)
 
 type Session struct {
-	err    error
-	alias  map[string][]string
-	fmap   map[string](func(*Session, []string) error)
-	cmds   []string
-	dir    string
-	Env    map[string]string
-	Stdin  io.Reader
-	Stdout io.Writer
-	Stderr io.Writer
+	err     error
+	alias   map[string]string
+	fmap    map[string](func(*Session, []string) error)
+	funcs   FuncMap // script functions, and the builtin each replaced
+	cmds    []string
+	dir     string
+	jobs    []*job
+	lastPid string
+	// exported variables are passed to external commands
+	exported map[string]bool
+	readonly map[string]bool
+	frames   []*frame
+	opts     options
+	policy   *Policy
+	sig      *signalState
+	pgroup   *pgroup
+	traps    map[string]string
+	ctx      context.Context
+	Env      map[string]string
+	Stdin    io.Reader
+	Stdout   io.Writer
+	Stderr   io.Writer
+
+	// HTTPClient is used by wget and http_last_mod, if set
+	HTTPClient *http.Client
+
+	// FS is the file system used by builtins, OSFS by default
+	FS FS
+
+	// Limits are the resource limits for external commands
+	Limits Limits
+
+	// Trace, if set, is called after each external command
+	// finishes.  It may be called from several goroutines.
+	Trace func(TraceEvent)
 }
 
 func New() *Session {
 	s := Session{}
 	s.Env = envMap(os.Environ())
-	s.alias = make(map[string][]string)
+	s.exported = make(map[string]bool, len(s.Env))
+	for k := range s.Env {
+		s.exported[k] = true
+	}
+	s.readonly = make(map[string]bool)
+	s.alias = make(map[string]string)
+	s.sig = &signalState{procs: make(map[*process]bool)}
+	s.traps = make(map[string]string)
+	s.funcs = make(FuncMap)
+	s.opts.braceexpand = true
+	s.FS = OSFS{}
 	s.fmap = FuncMap{
-		"alias":   Alias,
-		"cd":      Chdir,
-		"cp":      Copy,
-		"echo":    Echo,
-		"export":  Export,
-		"mkdir":   Mkdir,
-		"mv":      Move,
-		"unalias": Unalias,
-		"wget":    Wget,
-		"which":   Which,
-	}
-
-	// save current directory.
-	// restored on close
-	if dir, err := os.Getwd(); err != nil {
+		"alias":         Alias,
+		"cd":            Chdir,
+		"cp":            Copy,
+		"echo":          Echo,
+		"export":        Export,
+		"file_last_mod": FileLastModified,
+		"git_last_mod":  GitLastModified,
+		"gunzip":        Gzip,
+		"gzip":          Gzip,
+		"http_last_mod": HTTPLastModified,
+		"jobs":          Jobs,
+		"kill":          Kill,
+		"let":           Let,
+		"readonly":      Readonly,
+		"trap":          Trap,
+		"local":         Local,
+		"md5sum":        Md5sum,
+		"mkdir":         Mkdir,
+		"mv":            Move,
+		"parallel":      Parallel,
+		"return":        Return,
+		"set":           Set,
+		"sha1sum":       Sha1sum,
+		"sha256sum":     Sha256sum,
+		"sha512sum":     Sha512sum,
+		"tar":           Tar,
+		"unalias":       Unalias,
+		"unset":         Unset,
+		"unzip":         Unzip,
+		"wait":          Wait,
+		"wget":          Wget,
+		"xargs":         Xargs,
+		"which":         Which,
+		"zip":           Zip,
+	}
+
+	// session working directory starts as the current directory.
+	// cd changes it without changing the process directory
+	if dir, err := os.Getwd(); err == nil {
 		s.dir = dir
 	}
 
 	return &s
 }
 
-// Close returns the current process state to went it was started.
-func (s *Session) Close() error {
-	if len(s.dir) != 0 {
-		return os.Chdir(s.dir)
+// Clone makes a new session starting with copies of the environment,
+// aliases, functions and working directory.  Changes to the clone do
+// not affect the original, so it can be used for subshells or to run
+// commands concurrently.  The streams are shared.
+func (s *Session) Clone() *Session {
+	c := &Session{
+		opts:     s.opts,
+		policy:   s.policy,
+		sig:      s.sig,
+		pgroup:   s.pgroup,
+		traps:    make(map[string]string),
+		dir:      s.dir,
+		ctx:      s.ctx,
+		Env:      make(map[string]string, len(s.Env)),
+		exported: make(map[string]bool, len(s.exported)),
+		readonly: make(map[string]bool, len(s.readonly)),
+		alias:    make(map[string]string, len(s.alias)),
+		fmap:     make(FuncMap, len(s.fmap)),
+		funcs:    make(FuncMap, len(s.funcs)),
+		Stdin:    s.Stdin,
+		Stdout:   s.Stdout,
+		Stderr:   s.Stderr,
+
+		HTTPClient: s.HTTPClient,
+		FS:         s.FS,
+		Limits:     s.Limits,
+		Trace:      s.Trace,
+	}
+	for k, v := range s.Env {
+		c.Env[k] = v
+	}
+	for k := range s.exported {
+		c.exported[k] = true
+	}
+	for k := range s.readonly {
+		c.readonly[k] = true
+	}
+	// like a subshell, only ignored signals stay ignored
+	s.sig.mu.Lock()
+	for k, v := range s.traps {
+		if v == "" {
+			c.traps[k] = v
+		}
+	}
+	s.sig.mu.Unlock()
+	for k, v := range s.alias {
+		c.alias[k] = v
+	}
+	for k, v := range s.fmap {
+		c.fmap[k] = v
+	}
+	for k, v := range s.funcs {
+		c.funcs[k] = v
 	}
+	for _, f := range s.frames {
+		c.frames = append(c.frames, &frame{
+			args:  append([]string(nil), f.args...),
+			saved: make(map[string]savedVar),
+		})
+	}
+	return c
+}
+
+// context returns the context that external commands run under
+func (s *Session) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// Close runs the EXIT trap, then kills and waits for any background
+// jobs still running.  The session should not be used afterwards.
+func (s *Session) Close() error {
+	s.runExitTrap()
+	s.killJobs()
 	return nil
 }
+
+// httpClient returns the client for HTTP requests
+func (s *Session) httpClient() *http.Client {
+	if s.HTTPClient != nil {
+		return s.policy.policyClient(s.HTTPClient)
+	}
+	return s.policy.policyClient(&http.Client{})
+}
+
+// Dir returns the session working directory
+func (s *Session) Dir() string {
+	return s.dir
+}
+
+// Abs resolves a file name against the session working directory
+func (s *Session) Abs(name string) string {
+	if filepath.IsAbs(name) || len(s.dir) == 0 {
+		return name
+	}
+	return filepath.Join(s.dir, name)
+}
 func (s *Session) GetEnv(key string) string {
 	return s.Env[key]
 }
 
+// PutEnv sets a variable and exports it to external commands
 func (s *Session) PutEnv(key string, val string) {
 	s.Env[key] = val
+	s.exported[key] = true
+}
+
+// lookupVar returns the value of a shell variable, including
+// special variables such as "$!", and if it is set
+func (s *Session) lookupVar(key string) (string, bool) {
+	switch key {
+	case "!":
+		return s.lastPid, s.lastPid != ""
+	case "$":
+		return strconv.Itoa(os.Getpid()), true
+	}
+	if isPositional(key) {
+		return s.lookupPositional(key)
+	}
+	val, ok := s.Env[key]
+	return val, ok
 }
 
 func (s *Session) SetError(e error) {
@@ -79,7 +249,8 @@ func (s *Session) Error() error {
 }
 
 // Glob does the os.Glob but handles errors.
-//  useful in making for-loops
+//
+//	useful in making for-loops
 func (s *Session) Glob(pattern string) []string {
 	if s.Error() != nil {
 		return nil
@@ -87,10 +258,13 @@ func (s *Session) Glob(pattern string) []string {
 
 	// replace shell variables first
 	// e.g. "${BASE}/*.txt"
-	pattern = os.Expand(pattern,
-		(func(key string) string { return s.Env[key] }))
+	pattern, err := s.expand(pattern, nil)
+	if err != nil {
+		s.SetError(err)
+		return nil
+	}
 
-	match, err := filepath.Glob(pattern)
+	match, err := s.glob(pattern)
 	if err != nil {
 		s.SetError(fmt.Errorf("Unable to glob: %s", err))
 		return nil
@@ -100,6 +274,7 @@ func (s *Session) Glob(pattern string) []string {
 
 func (s *Session) Funcs(funcs FuncMap) *Session {
 	for k, v := range funcs {
+		delete(s.funcs, k)
 		if v == nil {
 			delete(s.fmap, k)
 		} else {
@@ -110,36 +285,18 @@ func (s *Session) Funcs(funcs FuncMap) *Session {
 }
 
 func (s *Session) Script(str string) *Session {
-	// break apart script into single commands
-	s.cmds = unbreak(str)
+	s.cmds = []string{str}
 	return s
 }
 
-func (s *Session) Output() ([]byte, error) {
-	var stdout bytes.Buffer
-	var stderr bytes.Buffer
-	s.Stdout = &stdout
-	s.Stderr = &stderr
-
-	err := s.Run()
-	if err != nil {
-		return nil, err
-	}
-	return stdout.Bytes(), nil
-}
-
 func (s *Session) Exec(cmds ...string) error {
 	if s.Error() != nil {
 		return nil
 	}
-	switch len(cmds) {
-	case 0:
+	if len(cmds) == 0 {
 		return fmt.Errorf("Exec called without args?")
-	case 1:
-		s.cmds = unbreak(cmds[0])
-	default:
-		s.cmds = cmds
 	}
+	s.cmds = cmds
 	return s.Run()
 }
 
@@ -147,76 +304,217 @@ func (s *Session) Run() error {
 	if s.Error() != nil {
 		return nil
 	}
-	for _, cmd := range s.cmds {
-		// replace all ${  } shell variables
-		cmd = os.Expand(cmd,
-			(func(key string) string { return s.Env[key] }))
+	nodes, err := parseScript(strings.Join(s.cmds, "\n"))
+	if err != nil {
+		s.SetError(err)
+		return err
+	}
+	err = s.runNodes(nodes)
+	if err != nil {
+		s.SetError(err)
+		return err
+	}
+	return nil
+}
 
-		parts, err := shlex.Split(cmd)
-		if err != nil {
-			s.SetError(fmt.Errorf("Bad line %q: %s", cmd, err))
+// runNodes runs parsed statements, stopping at the first error
+func (s *Session) runNodes(nodes []*node) error {
+	for _, n := range nodes {
+		if err := s.checkSignals(n); err != nil {
 			return err
 		}
-		// effectively blank line
-		if len(parts) == 0 {
-			continue
+		var err error
+		switch n.kind {
+		case nodeSubshell:
+			err = s.subshell(n.body)
+		case nodeGroup:
+			err = s.runNodes(n.body)
+		case nodeFunc:
+			s.defineFunc(n.name, n.body)
+		default:
+			err = s.runLine(n)
+		}
+		if err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		log.Printf("RUNNING: %s", cmd)
+// subshell runs statements in a clone, so changes to the
+// environment or working directory do not leak out.
+func (s *Session) subshell(nodes []*node) error {
+	c := s.Clone()
+	defer c.Close()
+	return c.runNodes(nodes)
+}
 
-		// TODO: is arg0 an environment override
-		// only for external commands I think
+// runLine expands and runs a simple command.  Errors are returned
+// as a *ScriptError, unless the command already did.
+func (s *Session) runLine(n *node) error {
+	tail, restore := s.keepStderr()
+	args, err := s.runSimple(n)
+	restore()
+	if err == nil {
+		return nil
+	}
+	var serr *ScriptError
+	if _, ok := err.(returnStatus); ok || errors.As(err, &serr) {
+		return err
+	}
+	serr = &ScriptError{
+		Cmd:      n.cmd,
+		Args:     args,
+		Line:     n.line,
+		ExitCode: exitCode(err),
+		Err:      err,
+	}
+	if tail != nil {
+		serr.Stderr = tail.String()
+	}
+	return serr
+}
+
+// runSimple does the work of runLine, and returns the expanded args
+func (s *Session) runSimple(n *node) ([]string, error) {
+	words := rawWords(n.cmd)
+	k := 0
+	for k < len(words) && isAssignment(words[k]) {
+		k++
+	}
 
-		// Insert Alias
-		if newargs, ok := s.alias[parts[0]]; ok {
-			parts = append(newargs, parts[1:]...)
-			log.Printf("   ALIAS: %s", strings.Join(parts, " "))
+	// aliases are replaced before anything is expanded
+	if k < len(words) {
+		words = append(words[:k:k], s.expandAlias(words[k:])...)
+		for k < len(words) && isAssignment(words[k]) {
+			k++
 		}
+	}
 
-		fn, ok := s.fmap[parts[0]]
-		if ok {
-			err = fn(s, parts)
+	// only assignments sets shell variables, in order
+	if k == len(words) {
+		for _, w := range words {
+			key, val, err := s.assignment(w)
 			if err != nil {
-				s.SetError(err)
-				return err
+				return nil, err
+			}
+			if err := s.SetVar(key, val); err != nil {
+				return nil, err
 			}
-			continue
 		}
-		log.Printf("Shelling out... not in map: %s", parts[0])
-		// ok shell out
-		execCmd := exec.Command(parts[0], parts[1:]...)
+		return nil, nil
+	}
 
-		// set up environment
-		execCmd.Stdin = nil
-		execCmd.Stdout = s.Stdout
-		execCmd.Stderr = s.Stderr
+	// otherwise they are exported for just this command
+	vars := make(map[string]string, k)
+	for _, w := range words[:k] {
+		key, val, err := s.assignment(w)
+		if err != nil {
+			return nil, err
+		}
+		vars[key] = val
+	}
+	parts, err := s.expandArgs(words[k:])
+	if err != nil {
+		return nil, err
+	}
+	if k > 0 {
+		restore, err := s.override(vars)
+		if err != nil {
+			return parts, err
+		}
+		defer restore()
+	}
+	if n.input != nil {
+		text, err := s.hereDocText(n.input)
+		if err != nil {
+			return parts, err
+		}
+		stdin := s.Stdin
+		s.Stdin = strings.NewReader(text)
+		defer func() { s.Stdin = stdin }()
+	}
 
-		// TODO DIR/PATH
-		// TODO ENV
+	// effectively blank line
+	if len(parts) == 0 {
+		return nil, nil
+	}
 
-		err = execCmd.Run()
-		if err != nil {
-			s.SetError(err)
+	log.Printf("RUNNING: %s", strings.Join(parts, " "))
+
+	return parts, s.execArgs(n.cmd, parts, n.background)
+}
+
+// runArgs runs a single command that has already been expanded
+// and split into args.  An alias in the first arg is replaced,
+// with the rest of the args kept as they are.
+func (s *Session) runArgs(cmd string, parts []string, background bool) error {
+	if _, ok := s.alias[parts[0]]; ok {
+		words := make([]string, len(parts))
+		words[0] = parts[0]
+		for i, arg := range parts[1:] {
+			words[i+1] = quoteArg(arg)
+		}
+		words = s.expandAlias(words)
+		if len(words) == 0 {
+			return nil
+		}
+		var err error
+		if parts, err = s.expandArgs(words); err != nil {
 			return err
 		}
+		log.Printf("   ALIAS: %s", strings.Join(parts, " "))
+		if len(parts) == 0 {
+			return nil
+		}
 	}
-	return nil
+	return s.execArgs(cmd, parts, background)
 }
 
-func Export(s *Session, cli []string) error {
-	if len(cli) != 2 {
-		return fmt.Errorf("%s: Expected only 1 arg: got %v", cli[0], cli[1:])
+// execArgs runs a builtin, function or external command
+func (s *Session) execArgs(cmd string, parts []string, background bool) error {
+	fn, ok := s.fmap[parts[0]]
+	if ok && background {
+		return fmt.Errorf("%s: builtins can not run in the background", parts[0])
 	}
-	//name := cli[0]
-	kv := cli[1]
-	idx := strings.IndexByte(kv, '=')
-	if idx == -1 {
-		return fmt.Errorf("didnt find key/value")
+	if ok {
+		return fn(s, parts)
 	}
-	s.PutEnv(kv[:idx], kv[idx+1:])
-	return nil
+	log.Printf("Shelling out... not in map: %s", parts[0])
+	// ok shell out
+	path, err := s.lookPath(parts[0])
+	if err != nil {
+		return err
+	}
+	if err := s.policy.checkCommand(parts[0], path); err != nil {
+		return err
+	}
+	p := s.newProcess(cmd, path, parts)
+	if background {
+		return s.startJob(p)
+	}
+	return p.Run()
+}
+
+// lookPath finds a command using the session PATH, not the
+// PATH of the process.
+func (s *Session) lookPath(name string) (string, error) {
+	if strings.Contains(name, "/") {
+		return exec.LookPath(s.Abs(name))
+	}
+	for _, dir := range filepath.SplitList(s.Env["PATH"]) {
+		if dir == "" {
+			dir = "."
+		}
+		if path, err := exec.LookPath(filepath.Join(s.Abs(dir), name)); err == nil {
+			return path, nil
+		}
+	}
+	return "", &exec.Error{Name: name, Err: exec.ErrNotFound}
 }
 
+// Echo writes its args separated by spaces, with no newline at the
+// end.  There are no flags.
 func Echo(s *Session, cli []string) error {
 	//name := cli[0]
 	fargs := cli[1:]
@@ -233,7 +531,7 @@ func Which(s *Session, cli []string) error {
 	fargs := cli[1:]
 
 	// no flags
-	cmd, err := exec.LookPath(fargs[0])
+	cmd, err := s.lookPath(fargs[0])
 	if err != nil {
 		return err
 	}
@@ -241,13 +539,23 @@ func Which(s *Session, cli []string) error {
 	return nil
 }
 
+// Chdir changes the session working directory.  The process
+// working directory is not changed.
 func Chdir(s *Session, cli []string) error {
 	name := cli[0]
 	if len(cli) != 2 {
 		return fmt.Errorf("%s: must provide a directory", name)
 	}
-	dir := cli[1]
-	return os.Chdir(dir)
+	dir := s.Abs(cli[1])
+	if !fileIsDirectory(s.FS, dir) {
+		return fmt.Errorf("%s: %s: not a directory", name, cli[1])
+	}
+	if err := s.policy.checkDir(s.FS, cli[1], dir); err != nil {
+		return err
+	}
+	s.dir = filepath.Clean(dir)
+	s.PutEnv("PWD", s.dir)
+	return nil
 }
 
 func Mkdir(s *Session, cli []string) error {
@@ -262,9 +570,9 @@ func Mkdir(s *Session, cli []string) error {
 	}
 	for _, dirs := range f.Args() {
 		if parents {
-			err = os.MkdirAll(dirs, 0777)
+			err = s.FS.MkdirAll(s.Abs(dirs), 0777)
 		} else {
-			err = os.Mkdir(dirs, 0777)
+			err = s.FS.Mkdir(s.Abs(dirs), 0777)
 		}
 		if err != nil {
 			return err
@@ -273,97 +581,110 @@ func Mkdir(s *Session, cli []string) error {
 	return nil
 }
 
-func Alias(s *Session, cli []string) error {
-	name, fargs := cli[0], cli[1:]
-	f := flag.NewFlagSet(name, flag.ExitOnError)
-	for err := f.Parse(fargs); err != nil; {
-		return err
-	}
-	args := f.Args()
-	switch len(args) {
-	case 0:
-		return fmt.Errorf("%s requires at least one arg", name)
-	case 1:
-		aliasargs, ok := s.alias[args[0]]
+// expandAlias replaces the command name in the unexpanded words of
+// a command with its alias, and again if that starts with an alias.
+// A quoted name is not an alias.  An alias is not replaced inside
+// itself, so "alias ls='ls -F'" works and loops stop.
+func (s *Session) expandAlias(words []string) []string {
+	seen := make(map[string]bool)
+	for len(words) > 0 && !seen[words[0]] {
+		text, ok := s.alias[words[0]]
 		if !ok {
-			return fmt.Errorf("%s: not found %s", name, fargs[0])
+			break
 		}
-		s.Stdout.Write([]byte(strings.Join(aliasargs, " ")))
-		s.Stdout.Write([]byte("\n"))
-		return nil
-	default:
-		s.alias[args[0]] = args[1:]
-		return nil
+		seen[words[0]] = true
+		words = append(rawWords(text), words[1:]...)
 	}
+	return words
 }
 
-func Unalias(s *Session, cli []string) error {
+// Alias defines or prints aliases.
+//
+//	alias                  list all aliases
+//	alias name ...         print the aliases
+//	alias name=value ...   define the aliases
+//	alias name word ...    define name as the words
+//
+// The value is the start of a simple command, and is expanded each
+// time the alias is used, like the rest of the command.  Aliases
+// are printed in a form that can be run again.
+func Alias(s *Session, cli []string) error {
 	name, fargs := cli[0], cli[1:]
-	f := flag.NewFlagSet(name, flag.ExitOnError)
-	err := f.Parse(fargs)
-	if err != nil {
+	f := flag.NewFlagSet(name, flag.ContinueOnError)
+	if err := f.Parse(fargs); err != nil {
 		return err
 	}
 	args := f.Args()
-	switch len(args) {
-	case 0:
-		return fmt.Errorf("%s requires at least one arg", name)
-	case 1:
-		delete(s.alias, args[0])
+	if len(args) == 0 {
+		keys := make([]string, 0, len(s.alias))
+		for k := range s.alias {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s.printAlias(k)
+		}
+		return nil
+	}
+	if len(args) > 1 && !strings.Contains(args[0], "=") {
+		words := make([]string, len(args)-1)
+		for i, arg := range args[1:] {
+			words[i] = quoteArg(arg)
+		}
+		s.alias[args[0]] = strings.Join(words, " ")
 		return nil
-	default:
-		return fmt.Errorf("%s too many args", name)
 	}
+	var err error
+	for _, arg := range args {
+		key, val, hasVal := strings.Cut(arg, "=")
+		_, found := s.alias[key]
+		switch {
+		case key == "":
+			err = fmt.Errorf("%s: %s: invalid alias name", name, arg)
+		case hasVal:
+			s.alias[key] = val
+		case !found:
+			err = fmt.Errorf("%s: %s: not found", name, key)
+		default:
+			s.printAlias(key)
+		}
+	}
+	return err
 }
 
-func Wget(s *Session, cli []string) error {
+// printAlias prints an alias in a form that can be run again
+func (s *Session) printAlias(key string) {
+	fmt.Fprintf(s.Stdout, "alias %s=%s\n", key, quoteValue(s.alias[key]))
+}
+
+// Unalias removes aliases.
+//
+//	unalias name ...
+//	unalias -a         remove all aliases
+func Unalias(s *Session, cli []string) error {
 	name, fargs := cli[0], cli[1:]
-	f := flag.NewFlagSet(name, flag.ExitOnError)
-	flagMethod := f.String("method", "GET", "HTTP method")
-	flagOutput := f.String("O", "", "Output file")
-	flagNoClobber := f.Bool("nc", false, "No Clobber, do not download if file already exists")
-	err := f.Parse(fargs)
-	if err != nil {
+	f := flag.NewFlagSet(name, flag.ContinueOnError)
+	flagAll := f.Bool("a", false, "remove all aliases")
+	if err := f.Parse(fargs); err != nil {
 		return err
 	}
 	args := f.Args()
-	if len(args) != 1 {
-		return fmt.Errorf("%s requires exactly one arg, got %d", name, len(args))
+	if *flagAll {
+		clear(s.alias)
+		return nil
 	}
-	// this is the url
-	source := args[0]
-	if *flagOutput == "" {
-		*flagOutput = path.Base(source)
+	if len(args) == 0 {
+		return fmt.Errorf("%s requires at least one arg", name)
 	}
-
-	if *flagNoClobber {
-		if _, err = os.Stat(source); err == nil {
-			return nil
+	var err error
+	for _, arg := range args {
+		if _, ok := s.alias[arg]; !ok {
+			err = fmt.Errorf("%s: %s: not found", name, arg)
+			continue
 		}
+		delete(s.alias, arg)
 	}
-
-	client := &http.Client{}
-	req, err := http.NewRequest(*flagMethod, source, nil)
-	if err != nil {
-		return fmt.Errorf("%s: failed to create request: %s", name, err)
-	}
-	// req.Header.Add("If-None-Match", `W/"wyzzy"`)
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("%s: request failed: %s", name, err)
-	}
-	out, err := os.Create(*flagOutput)
-	if err != nil {
-		return fmt.Errorf("%s: unable to create output file %s", name, err)
-	}
-	defer resp.Body.Close()
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		out.Close()
-		os.Remove(*flagOutput)
-		return fmt.Errorf("%s: request copy failed %s", name, err)
-	}
-	return out.Close()
+	return err
 }
 
 // envMap converts an environment in []string{"k=v"}
@@ -401,39 +722,12 @@ func newEnviron(env map[string]string, inherit bool) []string { //map[string]str
 	return environ
 }
 
-// unbreak takes a lines and
-func unbreak(s string) []string {
-	lines := strings.Split(s, "\n")
-	cmds := make([]string, 0, len(lines))
-	last := ""
-	for _, line := range lines {
-		if strings.HasPrefix(line, "\\") {
-			last = last + line[0:len(line)-1]
-		} else if len(last) > 0 {
-			cmds = append(cmds, last+line)
-			last = ""
-		} else {
-			cmds = append(cmds, line)
-		}
-	}
-	if len(last) > 0 {
-		// dangle.. itgnore for now
-		cmds = append(cmds, last)
-	}
-	/*
-		for i, ll := range cmds {
-			log.Printf("LINE %d: %s", i+1, ll)
-		}
-	*/
-	return cmds
-}
-
 func Move(s *Session, cli []string) error {
 	name := cli[0]
 	fargs := cli[1:]
-	useGlob := false
 	f := flag.NewFlagSet(name, flag.ContinueOnError)
-	f.BoolVar(&useGlob, "glob", false, "treat sources as globs")
+	// unquoted args are globbed by the shell, -glob is no longer needed
+	f.Bool("glob", false, "ignored, kept for old scripts")
 	err := f.Parse(fargs)
 	if err != nil {
 		return err
@@ -444,27 +738,12 @@ func Move(s *Session, cli []string) error {
 	}
 
 	dest, src := args[len(args)-1], args[:len(args)-1]
-	if useGlob {
-		sources := []string{}
-		for _, val := range src {
-			var matches []string
-			matches, err = filepath.Glob(val)
-			if err != nil {
-				return err
-			}
-			sources = append(sources, matches...)
-		}
-		if len(sources) == 0 {
-			return fmt.Errorf("No matching files")
-		}
-		src = sources
-	}
 
-	if fileIsDirectory(dest) {
+	if fileIsDirectory(s.FS, s.Abs(dest)) {
 		for _, val := range src {
 			base := filepath.Base(val)
 			srcdest := filepath.Join(dest, base)
-			os.Rename(val, srcdest)
+			err = s.FS.Rename(s.Abs(val), s.Abs(srcdest))
 			if err != nil {
 				return fmt.Errorf("%s %s %s failed: %s",
 					name, val, srcdest, err)
@@ -477,17 +756,17 @@ func Move(s *Session, cli []string) error {
 	if len(src) != 1 {
 		return fmt.Errorf("Last arg is not a directory")
 	}
-	return os.Rename(src[0], dest)
+	return s.FS.Rename(s.Abs(src[0]), s.Abs(dest))
 }
 
-func copyFile(src, dst string) error {
+func copyFile(fsys FS, src, dst string) error {
 	log.Printf("---> Copying %s to %s", src, dst)
-	in, err := os.Open(src)
+	in, err := openFile(fsys, src)
 	if err != nil {
 		return err
 	}
 	defer in.Close()
-	out, err := os.Create(dst)
+	out, err := createFile(fsys, dst)
 	if err != nil {
 		return err
 	}
@@ -503,9 +782,9 @@ func copyFile(src, dst string) error {
 func Copy(s *Session, cli []string) error {
 	name := cli[0]
 	fargs := cli[1:]
-	useGlob := false
 	f := flag.NewFlagSet(name, flag.ContinueOnError)
-	f.BoolVar(&useGlob, "glob", false, "treat sources as globs")
+	// unquoted args are globbed by the shell, -glob is no longer needed
+	f.Bool("glob", false, "ignored, kept for old scripts")
 	err := f.Parse(fargs)
 	if err != nil {
 		return fmt.Errorf("%s: %s", name, err)
@@ -516,29 +795,12 @@ func Copy(s *Session, cli []string) error {
 	}
 
 	dest, src := args[len(args)-1], args[:len(args)-1]
-	if useGlob {
-		sources := []string{}
-		for _, val := range src {
-			var matches []string
-			matches, err = filepath.Glob(val)
-			if err != nil {
-				return fmt.Errorf("%s: glob for %q failed: %s",
-					name, val, err)
-			}
-			sources = append(sources, matches...)
-		}
-		if len(sources) == 0 {
-			return fmt.Errorf("%s: No matching files for %v",
-				name, src)
-		}
-		src = sources
-	}
 
-	if fileIsDirectory(dest) {
+	if fileIsDirectory(s.FS, s.Abs(dest)) {
 		for _, val := range src {
 			base := filepath.Base(val)
 			srcdest := filepath.Join(dest, base)
-			copyFile(val, srcdest)
+			err = copyFile(s.FS, s.Abs(val), s.Abs(srcdest))
 			if err != nil {
 				return fmt.Errorf("%s %s %s failed: %s",
 					name, val, srcdest, err)
@@ -551,5 +813,5 @@ func Copy(s *Session, cli []string) error {
 	if len(src) != 1 {
 		return fmt.Errorf("Last arg is not a directory")
 	}
-	return copyFile(src[0], dest)
+	return copyFile(s.FS, s.Abs(src[0]), s.Abs(dest))
 }
@@ -0,0 +1,87 @@
+package gsh
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEcho(t *testing.T) {
+	tests := []struct {
+		script string
+		want   string
+	}{
+		{"echo", ""},
+		{"echo a", "a"},
+		{"echo a   b", "a b"},
+		{"echo 'a   b'", "a   b"},
+		{"echo a\necho b", "ab"},
+		{"echo -n a", "-n a"},
+	}
+	for _, tt := range tests {
+		s := New()
+		var out strings.Builder
+		s.Stdout = &out
+		if err := s.Exec(tt.script); err != nil {
+			t.Errorf("%q: %v", tt.script, err)
+			continue
+		}
+		if out.String() != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.script, out.String(), tt.want)
+		}
+	}
+}
+
+func TestSubshell(t *testing.T) {
+	tests := []struct {
+		script string
+		want   string
+		err    bool
+	}{
+		{"x=1\n(x=2; echo $x)\necho $x", "21", false},
+		{"(export y=2)\necho ${y:-unset}", "unset", false},
+		{"(cd /; echo $PWD)", "/", false},
+		{"(alias e=echo)\ne x", "", true},
+		{"(f() { echo f; })\nf", "", true},
+		{"(\n  x=1\n  (x=2; echo $x)\n  echo $x\n)", "21", false},
+		{"(false)\necho after", "", true},
+		{"(echo a; false; echo b)", "a", true},
+	}
+	for _, tt := range tests {
+		s := New()
+		var out strings.Builder
+		s.Stdout = &out
+		dir := s.Dir()
+		err := s.Exec(tt.script)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: error %v", tt.script, err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.script, out.String(), tt.want)
+		}
+		if s.Dir() != dir {
+			t.Errorf("%q: working directory changed to %s", tt.script, s.Dir())
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	s := New()
+	s.Exec("x=1", "alias e=echo", "f() { echo f; }")
+	c := s.Clone()
+	var out strings.Builder
+	c.Stdout = &out
+	if err := c.Exec("e $x", "f", "x=2", "unalias e", "unset -f f", "cd /"); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "1f" {
+		t.Errorf("clone got %q", out.String())
+	}
+	out.Reset()
+	s.Stdout = &out
+	if err := s.Exec("e $x", "f"); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "1f" || s.Dir() == "/" {
+		t.Errorf("original got %q in %s", out.String(), s.Dir())
+	}
+}
@@ -0,0 +1,308 @@
+package gsh
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// handledSignals are the signals HandleSignals forwards and trap
+// can catch
+var handledSignals = []os.Signal{syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM}
+
+// signalState is shared by a session and its clones.  It tracks
+// the running external commands so signals can be forwarded to them.
+type signalState struct {
+	mu      sync.Mutex
+	procs   map[*process]bool
+	owner   *Session // the session handling signals, if any
+	cancel  context.CancelFunc
+	pending []syscall.Signal // trapped signals waiting to run
+	killed  syscall.Signal   // a signal that stops the script
+}
+
+// pgroup is the process group of a pipeline, shared by its commands
+type pgroup struct {
+	mu   sync.Mutex
+	pgid int
+}
+
+// signalError is a script stopped by a signal
+type signalError struct {
+	sig syscall.Signal
+}
+
+func (e signalError) Error() string {
+	return "stopped by signal: " + e.sig.String()
+}
+
+// HandleSignals makes the session handle SIGHUP, SIGINT and SIGTERM
+// sent to the program, until stop is called:
+//
+//   - with "trap 'command' SIGNAL" the command runs after the
+//     current command is done
+//   - with an empty command the signal is ignored
+//   - otherwise the signal is sent on to the running commands and
+//     the script stops, with exit status 128 plus the signal number
+//
+// While signals are handled, external commands run in their own
+// process group, one per pipeline, so they only get signals through
+// the session.  A command reading from a terminal stays in the group
+// of the program so it can use the terminal, and gets Ctrl-C from it
+// directly.  Without HandleSignals every command stays in the group
+// of the program.
+//
+// stop restores the context of the session, and forgets a signal
+// that stopped the script, so the session can be used again.
+func (s *Session) HandleSignals() (stop func()) {
+	prev := s.ctx
+	ctx, cancel := context.WithCancel(s.context())
+	s.ctx = ctx
+	s.sig.mu.Lock()
+	s.sig.owner = s
+	s.sig.cancel = cancel
+	s.sig.mu.Unlock()
+
+	ch := make(chan os.Signal, 4)
+	done := make(chan struct{})
+	signal.Notify(ch, handledSignals...)
+	go func() {
+		for {
+			select {
+			case sig := <-ch:
+				s.sig.handle(sig.(syscall.Signal))
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(done)
+		s.sig.mu.Lock()
+		s.sig.owner = nil
+		s.sig.cancel = nil
+		s.sig.killed = 0
+		s.sig.pending = nil
+		s.sig.mu.Unlock()
+		cancel()
+		s.ctx = prev
+	}
+}
+
+// handle acts on a signal sent to the program
+func (st *signalState) handle(sig syscall.Signal) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.owner == nil {
+		return
+	}
+	cmd, trapped := st.owner.traps[signalName(sig)]
+	switch {
+	case trapped && cmd == "":
+		log.Printf("signal %s ignored", sig)
+	case trapped:
+		st.pending = append(st.pending, sig)
+	default:
+		if st.killed == 0 {
+			st.killed = sig
+		}
+		for p := range st.procs {
+			p.forward(sig)
+		}
+		st.cancel()
+	}
+}
+
+// checkSignals runs the traps of signals that have arrived, or
+// returns an error if the script should stop.
+func (s *Session) checkSignals(n *node) error {
+	s.sig.mu.Lock()
+	killed := s.sig.killed
+	var pending []syscall.Signal
+	if s.sig.owner == s {
+		pending, s.sig.pending = s.sig.pending, nil
+	}
+	s.sig.mu.Unlock()
+	if killed != 0 {
+		return &ScriptError{
+			Line:     n.line,
+			ExitCode: 128 + int(killed),
+			Err:      signalError{killed},
+		}
+	}
+	for _, sig := range pending {
+		s.runTrap(signalName(sig))
+	}
+	return nil
+}
+
+// runTrap runs the command set for a trap.  Errors are logged but
+// do not stop the script, as in the shell.
+func (s *Session) runTrap(name string) {
+	s.sig.mu.Lock()
+	cmd := s.traps[name]
+	s.sig.mu.Unlock()
+	if cmd == "" {
+		return
+	}
+	nodes, err := parseScript(cmd)
+	if err == nil {
+		err = s.runNodes(nodes)
+	}
+	if err != nil {
+		log.Printf("trap %s: %s", name, err)
+	}
+}
+
+// runExitTrap runs the EXIT trap once, even if the script failed
+// or was stopped by a signal.
+func (s *Session) runExitTrap() {
+	s.sig.mu.Lock()
+	cmd, ok := s.traps["EXIT"]
+	delete(s.traps, "EXIT")
+	if ok && s.sig.owner == s {
+		// the trap itself can still be stopped by another signal
+		s.sig.killed = 0
+	}
+	s.sig.mu.Unlock()
+	if !ok || cmd == "" {
+		return
+	}
+	defer func(ctx context.Context) { s.ctx = ctx }(s.ctx)
+	s.ctx = context.WithoutCancel(s.context())
+	nodes, err := parseScript(cmd)
+	if err == nil {
+		err = s.runNodes(nodes)
+	}
+	if err != nil {
+		log.Printf("trap EXIT: %s", err)
+	}
+}
+
+// track records a running external command, so signals can be
+// forwarded to it.
+func (st *signalState) track(p *process, running bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if running {
+		st.procs[p] = true
+	} else {
+		delete(st.procs, p)
+	}
+}
+
+// forward sends a signal to a command.  A command in the process
+// group of the program already got terminal signals.
+func (p *process) forward(sig syscall.Signal) {
+	if p.grouped || sig == syscall.SIGTERM {
+		p.signal(sig)
+	}
+}
+
+// signalName is the name of a signal as used by trap
+func signalName(sig syscall.Signal) string {
+	for name, s := range signals {
+		if s == sig {
+			return name
+		}
+	}
+	return fmt.Sprint(int(sig))
+}
+
+// isTerminal reports if r is a terminal, so a command using it
+// should stay in the foreground process group
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok || f == nil {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(info, null)
+}
+
+// Trap sets commands to run when the script gets a signal or exits.
+//
+//	trap 'command' SIGNAL ...   run command, SIGNAL is HUP, INT, TERM or EXIT
+//	trap '' SIGNAL ...          ignore the signal
+//	trap - SIGNAL ...           restore the default action
+//	trap                        list the traps
+//
+// Signals are only caught if the program called HandleSignals.  The
+// EXIT trap runs when the session is closed, and a subshell runs its
+// own EXIT trap when it is done.
+func Trap(s *Session, cli []string) error {
+	name, args := cli[0], cli[1:]
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		s.listTraps()
+		return nil
+	}
+	if len(args) == 1 {
+		return fmt.Errorf("%s: requires a command and signals", name)
+	}
+	cmd, specs := args[0], args[1:]
+	keys := make([]string, len(specs))
+	for i, spec := range specs {
+		key, err := trapName(spec)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		keys[i] = key
+	}
+	s.sig.mu.Lock()
+	defer s.sig.mu.Unlock()
+	for _, key := range keys {
+		if cmd == "-" {
+			delete(s.traps, key)
+		} else {
+			s.traps[key] = cmd
+		}
+	}
+	return nil
+}
+
+// trapName converts "EXIT", "0", "SIGINT", "INT" or "2" to the name
+// a trap is kept under
+func trapName(spec string) (string, error) {
+	if spec == "0" || strings.ToUpper(spec) == "EXIT" {
+		return "EXIT", nil
+	}
+	sig, err := parseSignal(spec)
+	if err != nil {
+		return "", err
+	}
+	for _, h := range handledSignals {
+		if h == sig {
+			return signalName(sig), nil
+		}
+	}
+	return "", fmt.Errorf("%s: signal can not be trapped", spec)
+}
+
+// listTraps prints the traps in a form that can be run again
+func (s *Session) listTraps() {
+	s.sig.mu.Lock()
+	defer s.sig.mu.Unlock()
+	keys := make([]string, 0, len(s.traps))
+	for k := range s.traps {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(s.Stdout, "trap -- %s %s\n", quoteValue(s.traps[k]), k)
+	}
+}
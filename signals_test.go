@@ -0,0 +1,151 @@
+//go:build unix
+
+package gsh
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// sendSignal sends a signal to the test after a delay
+func sendSignal(sig syscall.Signal, delay time.Duration) {
+	go func() {
+		time.Sleep(delay)
+		syscall.Kill(os.Getpid(), sig)
+	}()
+}
+
+func TestTrap(t *testing.T) {
+	tests := []struct {
+		script string
+		want   string
+		err    bool
+	}{
+		{"trap 'echo bye' EXIT\necho main", "mainbye", false},
+		{"trap 'echo bye' 0\nfalse", "bye", true},
+		{"trap 'echo bye' EXIT\ntrap - EXIT", "", false},
+		{"trap 'echo bye' EXIT\ntrap '' HUP\ntrap", "trap -- 'echo bye' EXIT\ntrap -- '' HUP\nbye", false},
+		{"( trap 'echo sub' EXIT\necho in )\necho main", "insubmain", false},
+		{"trap 'echo int' SIGINT\ntrap", "trap -- 'echo int' INT\n", false},
+		{"trap x FOO", "", true},
+		{"trap x KILL", "", true},
+		{"trap x", "", true},
+	}
+	for _, tt := range tests {
+		s := New()
+		var out strings.Builder
+		s.Stdout = &out
+		err := s.Exec(tt.script)
+		s.Close()
+		if (err != nil) != tt.err {
+			t.Errorf("%q: error %v", tt.script, err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.script, out.String(), tt.want)
+		}
+	}
+}
+
+func TestSignalStops(t *testing.T) {
+	s := New()
+	stop := s.HandleSignals()
+	var out strings.Builder
+	s.Stdout = &out
+	sendSignal(syscall.SIGTERM, 200*time.Millisecond)
+	start := time.Now()
+	err := s.Exec("trap 'echo bye' EXIT", "sleep 5", "echo after")
+	s.runExitTrap()
+	var serr *ScriptError
+	if !errors.As(err, &serr) || serr.ExitCode != 128+int(syscall.SIGTERM) {
+		t.Errorf("got %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("sleep not stopped, took %s", time.Since(start))
+	}
+	if out.String() != "bye" {
+		t.Errorf("got %q", out.String())
+	}
+
+	// the session can be used again once stop is called
+	stop()
+	if s.ctx != nil {
+		t.Error("context not restored")
+	}
+	s.SetError(nil)
+	out.Reset()
+	if err := s.Exec("echo again", "sh -c 'echo ext'"); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "againext\n" {
+		t.Errorf("got %q", out.String())
+	}
+}
+
+func TestSignalTrapped(t *testing.T) {
+	s := New()
+	stop := s.HandleSignals()
+	defer stop()
+	var out strings.Builder
+	s.Stdout = &out
+	sendSignal(syscall.SIGINT, 200*time.Millisecond)
+	err := s.Exec("trap 'echo got int' INT", "trap '' HUP", "sleep 0.5", "echo after")
+	if err != nil || out.String() != "got intafter" {
+		t.Errorf("got %q, %v", out.String(), err)
+	}
+}
+
+func TestPipeGroup(t *testing.T) {
+	s := New()
+	stop := s.HandleSignals()
+	defer stop()
+	var out strings.Builder
+	s.Stdout = &out
+	stat := "cut -d' ' -f5 /proc/$$/stat"
+	err := s.Pipe(s.Cmd("sh", "-c", stat+"; sleep 0.1"), s.Cmd("sh", "-c", "cat; "+stat)).Run()
+	if err != nil {
+		t.Skip(err)
+	}
+	f := strings.Fields(out.String())
+	if len(f) != 2 || f[0] != f[1] || f[0] == strconv.Itoa(syscall.Getpgrp()) {
+		t.Errorf("process groups %q, test is in %d", f, syscall.Getpgrp())
+	}
+}
+
+func TestPipeGroupLeaderExited(t *testing.T) {
+	// a command may start after the group leader has exited and been
+	// waited for, which removes the group
+	for i := 0; i < 100; i++ {
+		s := New()
+		stop := s.HandleSignals()
+		err := s.Pipe(s.Cmd("sh", "-c", "exit 2"), s.Cmd("true")).Run()
+		stop()
+		if got := exitCode(err); got != 2 {
+			t.Fatalf("got status %d (%v), want 2", got, err)
+		}
+	}
+}
+
+func TestNoProcessGroup(t *testing.T) {
+	// without HandleSignals commands stay in the group of the program
+	s := New()
+	var out strings.Builder
+	s.Stdout = &out
+	stat := "cut -d' ' -f5 /proc/$$/stat"
+	err := s.Exec("sh -c \""+stat+"\"", "sh -c \""+stat+"\" &", "wait")
+	if err == nil {
+		err = s.Pipe(s.Cmd("sh", "-c", stat), s.Cmd("sh", "-c", "cat; "+stat)).Run()
+	}
+	if err != nil {
+		t.Skip(err)
+	}
+	pgrp := strconv.Itoa(syscall.Getpgrp())
+	f := strings.Fields(out.String())
+	if len(f) != 4 || f[0] != pgrp || f[1] != pgrp || f[2] != pgrp || f[3] != pgrp {
+		t.Errorf("process groups %q, test is in %s", f, pgrp)
+	}
+}
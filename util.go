@@ -0,0 +1,41 @@
+package gsh
+
+import (
+	"bufio"
+	"io"
+)
+
+// ForEachLine calls f on each arg, or if there are no args, on each
+// line of stdin.  The results are written to stdout one per line.
+func ForEachLine(s *Session, args []string, f func(line []byte) ([]byte, error)) error {
+	for _, arg := range args {
+		line, err := f([]byte(arg))
+		if err != nil {
+			return err
+		}
+		_, err = s.Stdout.Write(line)
+		if err != nil {
+			return err
+		}
+		s.Stdout.Write([]byte{'\n'})
+	}
+
+	// we are done
+	if len(args) > 0 || s.Stdin == nil {
+		return nil
+	}
+
+	scanner := bufio.NewScanner(s.Stdin)
+	for scanner.Scan() {
+		line, err := f(scanner.Bytes())
+		if err != nil {
+			return err
+		}
+		s.Stdout.Write(line)
+		s.Stdout.Write([]byte{'\n'})
+	}
+	if err := scanner.Err(); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
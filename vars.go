@@ -0,0 +1,254 @@
+package gsh
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Shell variables are kept in Session.Env.  Only variables marked
+// as exported are passed to external commands, and New marks the
+// variables of the process environment as exported.
+//
+//	NAME=value            set a shell variable
+//	NAME=value cmd args   set NAME only for cmd, and export it
+//	export NAME[=value]   export to external commands
+//	readonly NAME[=value] make a variable unchangeable
+//	unset NAME            remove a variable
+
+// isAssignment reports if an unexpanded word is "NAME=value"
+func isAssignment(word string) bool {
+	if word == "" || !isNameStart(word[0]) {
+		return false
+	}
+	for i := 1; i < len(word); i++ {
+		if word[i] == '=' {
+			return true
+		}
+		if !isNameChar(word[i]) {
+			return false
+		}
+	}
+	return false
+}
+
+// SetVar sets a shell variable, unless it is readonly.  Unlike
+// PutEnv it is not exported, unless it was before.
+func (s *Session) SetVar(key string, val string) error {
+	if s.readonly[key] {
+		return fmt.Errorf("%s: readonly variable", key)
+	}
+	s.Env[key] = val
+	return nil
+}
+
+// environ returns the exported variables for an external command
+func (s *Session) environ() []string {
+	keys := make([]string, 0, len(s.exported))
+	for k := range s.exported {
+		if _, ok := s.Env[k]; ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	env := make([]string, len(keys))
+	for i, k := range keys {
+		env[i] = k + "=" + s.Env[k]
+	}
+	return env
+}
+
+// assignment expands an unexpanded "NAME=value" word.  The value
+// is not split or globbed.
+func (s *Session) assignment(word string) (string, string, error) {
+	key, val, _ := strings.Cut(word, "=")
+	val, err := s.expandString(val)
+	return key, val, err
+}
+
+// declarations are the builtins that take "NAME=value" args, which
+// are expanded like assignments.
+var declarations = map[string]bool{
+	"export":   true,
+	"local":    true,
+	"readonly": true,
+}
+
+// expandArgs expands the unexpanded words of a command
+func (s *Session) expandArgs(words []string) ([]string, error) {
+	if !declarations[words[0]] {
+		return s.expandWords(strings.Join(words, " "))
+	}
+	args := []string{words[0]}
+	for _, w := range words[1:] {
+		if isAssignment(w) {
+			key, val, err := s.assignment(w)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, key+"="+val)
+			continue
+		}
+		fields, err := s.expandWords(w)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, fields...)
+	}
+	return args, nil
+}
+
+// override sets variables for the duration of one command, and
+// exports them.  The returned func restores the old values.
+func (s *Session) override(vars map[string]string) (func(), error) {
+	old := make(map[string]savedVar, len(vars))
+	restore := func() {
+		for k, v := range old {
+			if v.set {
+				s.Env[k] = v.val
+			} else {
+				delete(s.Env, k)
+			}
+			if !v.exported {
+				delete(s.exported, k)
+			}
+		}
+	}
+	for k, v := range vars {
+		if s.readonly[k] {
+			restore()
+			return nil, fmt.Errorf("%s: readonly variable", k)
+		}
+		val, set := s.Env[k]
+		old[k] = savedVar{val: val, set: set, exported: s.exported[k]}
+		s.Env[k] = v
+		s.exported[k] = true
+	}
+	return restore, nil
+}
+
+// quoteValue quotes a value so it can be read back by the shell
+func quoteValue(val string) string {
+	return "'" + strings.ReplaceAll(val, "'", `'\''`) + "'"
+}
+
+// listVars prints variables in a form that can be run again
+func (s *Session) listVars(cmd string, names map[string]bool) {
+	keys := make([]string, 0, len(names))
+	for k := range names {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if val, ok := s.Env[k]; ok {
+			fmt.Fprintf(s.Stdout, "%s %s=%s\n", cmd, k, quoteValue(val))
+		} else {
+			fmt.Fprintf(s.Stdout, "%s %s\n", cmd, k)
+		}
+	}
+}
+
+// declare does the work of export and readonly.  Each arg is
+// "NAME" or "NAME=value", and mark is applied to each name.
+func (s *Session) declare(name string, args []string, mark func(string)) error {
+	for _, arg := range args {
+		key, val, hasVal := strings.Cut(arg, "=")
+		if key == "" || !isAssignment(key+"=") {
+			return fmt.Errorf("%s: %q: not a valid identifier", name, arg)
+		}
+		if hasVal {
+			if err := s.SetVar(key, val); err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+		}
+		mark(key)
+	}
+	return nil
+}
+
+// Export marks variables to be passed to external commands.
+//
+//	export NAME[=value] ...
+//	export -n NAME ...       stop exporting
+//	export -p                list exported variables
+func Export(s *Session, cli []string) error {
+	name, fargs := cli[0], cli[1:]
+	f := flag.NewFlagSet(name, flag.ContinueOnError)
+	flagList := f.Bool("p", false, "list exported variables")
+	flagRemove := f.Bool("n", false, "remove the export property")
+	err := f.Parse(fargs)
+	if err != nil {
+		return err
+	}
+	args := f.Args()
+	if len(args) == 0 || *flagList {
+		s.listVars("export", s.exported)
+		return nil
+	}
+	return s.declare(name, args, func(key string) {
+		if *flagRemove {
+			delete(s.exported, key)
+		} else {
+			s.exported[key] = true
+		}
+	})
+}
+
+// Readonly makes variables unchangeable.
+//
+//	readonly NAME[=value] ...
+//	readonly -p              list readonly variables
+func Readonly(s *Session, cli []string) error {
+	name, fargs := cli[0], cli[1:]
+	f := flag.NewFlagSet(name, flag.ContinueOnError)
+	flagList := f.Bool("p", false, "list readonly variables")
+	err := f.Parse(fargs)
+	if err != nil {
+		return err
+	}
+	args := f.Args()
+	if len(args) == 0 || *flagList {
+		s.listVars("readonly", s.readonly)
+		return nil
+	}
+	return s.declare(name, args, func(key string) {
+		s.readonly[key] = true
+	})
+}
+
+// Unset removes variables, or with -f script functions.  Go
+// builtins are not removed.
+//
+//	unset [-v] NAME ...
+//	unset -f NAME ...
+func Unset(s *Session, cli []string) error {
+	name, fargs := cli[0], cli[1:]
+	f := flag.NewFlagSet(name, flag.ContinueOnError)
+	flagFunc := f.Bool("f", false, "remove functions")
+	f.Bool("v", true, "remove variables")
+	err := f.Parse(fargs)
+	if err != nil {
+		return err
+	}
+	for _, key := range f.Args() {
+		if *flagFunc {
+			builtin, ok := s.funcs[key]
+			switch {
+			case !ok:
+			case builtin != nil:
+				s.fmap[key] = builtin
+			default:
+				delete(s.fmap, key)
+			}
+			delete(s.funcs, key)
+			continue
+		}
+		if s.readonly[key] {
+			return fmt.Errorf("%s: %s: cannot unset: readonly variable", name, key)
+		}
+		delete(s.Env, key)
+		delete(s.exported, key)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package gsh
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVars(t *testing.T) {
+	tests := []struct {
+		script string
+		want   string
+		err    bool
+	}{
+		{"local=1\nexport shared=2\nsh -c 'echo \"[$local] [$shared]\"'", "[] [2]\n", false},
+		{"x=1\nexport x\nexport -n x\nsh -c 'echo \"[$x]\"'", "[]\n", false},
+		{"export x=1\nx=2\nsh -c 'echo $x'", "2\n", false},
+		{"x=1 sh -c 'echo $x'\necho \"[$x]\"", "1\n[]", false},
+		{"x=1\nx=2 sh -c 'echo $x'\necho $x\nsh -c 'echo \"[$x]\"'", "2\n1[]\n", false},
+		{"export -n PATH\nexport a=1 b='x y'\nexport -p", "export a='1'\nexport b='x y'\n", false},
+		{"readonly r=1\nreadonly -p", "readonly r='1'\n", false},
+		{"readonly r=1\nr=2", "", true},
+		{"readonly r=1\nunset r", "", true},
+		{"readonly r=1\nr=2 echo", "", true},
+		{"x=1\nunset x\necho \"[$x]\"", "[]", false},
+		{"export 1x=2", "", true},
+		{"export a-b", "", true},
+	}
+	for _, tt := range tests {
+		s := New()
+		s.Env = map[string]string{"PATH": s.Env["PATH"]}
+		s.exported = map[string]bool{"PATH": true}
+		var out strings.Builder
+		s.Stdout = &out
+		err := s.Exec(tt.script)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: error %v", tt.script, err)
+			continue
+		}
+		if out.String() != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.script, out.String(), tt.want)
+		}
+	}
+}
+
+func TestUnsetFunc(t *testing.T) {
+	tests := []struct {
+		script string
+		want   string
+		err    bool
+	}{
+		{"f() { echo f; }\nf\nunset -f f\nf", "f", true},
+		{"unset -f echo\necho a", "a", false},
+		{"unset -f nosuch\necho a", "a", false},
+		{"let() { echo fn; }\nlet x=3\nunset -f let\nlet x=4\necho $x", "fn4", false},
+		{"let() { echo 1; }\nlet() { echo 2; }\nlet x=3\nunset -f let\nlet x=4\necho $x", "24", false},
+		{"f=1\nf() { echo f; }\nunset -f f\necho $f", "1", false},
+	}
+	for _, tt := range tests {
+		s := New()
+		s.Env["PATH"] = ""
+		var out strings.Builder
+		s.Stdout = &out
+		err := s.Exec(tt.script)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: error %v", tt.script, err)
+			continue
+		}
+		if out.String() != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.script, out.String(), tt.want)
+		}
+	}
+
+	// a clone has its own functions
+	s := New()
+	s.Exec("let() { echo fn; }")
+	c := s.Clone()
+	c.Exec("unset -f let")
+	var out strings.Builder
+	s.Stdout, c.Stdout = &out, &out
+	s.Exec("let x=1")
+	c.Exec("let x=2", "echo $x")
+	if out.String() != "fn2" {
+		t.Errorf("clone: got %q, want %q", out.String(), "fn2")
+	}
+}
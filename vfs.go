@@ -0,0 +1,207 @@
+package gsh
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FS is the file system used by builtins, glob and the file tests.
+// Names are absolute paths, already resolved against the session
+// working directory.  External commands always use the real file
+// system.
+//
+// The errors should be *fs.PathError wrapping fs.ErrNotExist and
+// such, as returned by the os package.
+type FS interface {
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+	Stat(name string) (fs.FileInfo, error)
+	Lstat(name string) (fs.FileInfo, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Mkdir(name string, perm fs.FileMode) error
+	MkdirAll(name string, perm fs.FileMode) error
+	Remove(name string) error
+	Rename(oldname string, newname string) error
+	Chmod(name string, mode fs.FileMode) error
+	Chtimes(name string, atime time.Time, mtime time.Time) error
+	Symlink(oldname string, newname string) error
+	Readlink(name string) (string, error)
+	Link(oldname string, newname string) error
+}
+
+// File is an open file of an FS
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.Closer
+	Stat() (fs.FileInfo, error)
+}
+
+// OSFS is the real file system, and the default
+type OSFS struct{}
+
+func (OSFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		// avoid a non-nil File holding a nil *os.File
+		return nil, err
+	}
+	return f, nil
+}
+
+func (OSFS) Stat(name string) (fs.FileInfo, error)        { return os.Stat(name) }
+func (OSFS) Lstat(name string) (fs.FileInfo, error)       { return os.Lstat(name) }
+func (OSFS) ReadDir(name string) ([]fs.DirEntry, error)   { return os.ReadDir(name) }
+func (OSFS) Mkdir(name string, perm fs.FileMode) error    { return os.Mkdir(name, perm) }
+func (OSFS) MkdirAll(name string, perm fs.FileMode) error { return os.MkdirAll(name, perm) }
+func (OSFS) Remove(name string) error                     { return os.Remove(name) }
+func (OSFS) Rename(oldname string, newname string) error  { return os.Rename(oldname, newname) }
+func (OSFS) Chmod(name string, mode fs.FileMode) error    { return os.Chmod(name, mode) }
+func (OSFS) Symlink(oldname string, newname string) error { return os.Symlink(oldname, newname) }
+func (OSFS) Readlink(name string) (string, error)         { return os.Readlink(name) }
+func (OSFS) Link(oldname string, newname string) error    { return os.Link(oldname, newname) }
+func (OSFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+// ErrReadOnly is the error for changes to a ReadOnly file system.
+// It is also fs.ErrPermission for errors.Is.
+var ErrReadOnly error = readOnlyErr{}
+
+type readOnlyErr struct{}
+
+func (readOnlyErr) Error() string        { return "read-only file system" }
+func (readOnlyErr) Is(target error) bool { return target == fs.ErrPermission }
+
+// ReadOnly wraps a file system so it can be read but not changed
+func ReadOnly(fsys FS) FS {
+	return readOnlyFS{fsys}
+}
+
+type readOnlyFS struct {
+	FS
+}
+
+func readOnlyError(op string, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: ErrReadOnly}
+}
+
+func (r readOnlyFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, readOnlyError("open", name)
+	}
+	f, err := r.FS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return readOnlyFile{f, name}, nil
+}
+
+func (readOnlyFS) Mkdir(name string, perm fs.FileMode) error {
+	return readOnlyError("mkdir", name)
+}
+
+func (readOnlyFS) MkdirAll(name string, perm fs.FileMode) error {
+	return readOnlyError("mkdir", name)
+}
+
+func (readOnlyFS) Remove(name string) error {
+	return readOnlyError("remove", name)
+}
+
+func (readOnlyFS) Rename(oldname string, newname string) error {
+	return readOnlyError("rename", oldname)
+}
+
+func (readOnlyFS) Chmod(name string, mode fs.FileMode) error {
+	return readOnlyError("chmod", name)
+}
+
+func (readOnlyFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return readOnlyError("chtimes", name)
+}
+
+func (readOnlyFS) Symlink(oldname string, newname string) error {
+	return readOnlyError("symlink", newname)
+}
+
+func (readOnlyFS) Link(oldname string, newname string) error {
+	return readOnlyError("link", newname)
+}
+
+// readOnlyFile is a file that was opened for reading
+type readOnlyFile struct {
+	File
+	name string
+}
+
+func (f readOnlyFile) Write(p []byte) (int, error) {
+	return 0, readOnlyError("write", f.name)
+}
+
+// openFile opens a file for reading
+func openFile(fsys FS, name string) (File, error) {
+	return fsys.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// createFile creates or truncates a file for writing
+func createFile(fsys FS, name string) (File, error) {
+	return fsys.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// readFile reads all of a file
+func readFile(fsys FS, name string) ([]byte, error) {
+	f, err := openFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// walk calls fn for root and every file under it, like
+// filepath.Walk.  Symlinks are not followed.
+func walk(fsys FS, root string, fn filepath.WalkFunc) error {
+	info, err := fsys.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkDir(fsys, root, info, fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walkDir(fsys FS, path string, info fs.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+	entries, err := fsys.ReadDir(path)
+	err1 := fn(path, info, err)
+	if err != nil || err1 != nil {
+		// as filepath.Walk, fn decides if a ReadDir error stops the walk
+		return err1
+	}
+	for _, e := range entries {
+		fpath := filepath.Join(path, e.Name())
+		info, err := fsys.Lstat(fpath)
+		if err != nil {
+			if err := fn(fpath, info, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+		err = walkDir(fsys, fpath, info, fn)
+		if err != nil {
+			if !info.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
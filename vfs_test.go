@@ -0,0 +1,52 @@
+package gsh
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReadOnly(t *testing.T) {
+	ro := ReadOnly(testMemFS(t))
+	writes := map[string]func() error{
+		"create":   func() error { return memWrite(ro, "/a/new", os.O_WRONLY|os.O_CREATE, "x") },
+		"truncate": func() error { return memWrite(ro, "/a/f", os.O_RDONLY|os.O_TRUNC, "") },
+		"append":   func() error { return memWrite(ro, "/a/f", os.O_WRONLY|os.O_APPEND, "x") },
+		"rdwr":     func() error { return memWrite(ro, "/a/f", os.O_RDWR, "x") },
+		"write":    func() error { return memWrite(ro, "/a/f", os.O_RDONLY, "x") },
+		"mkdir":    func() error { return ro.Mkdir("/a/c", 0755) },
+		"mkdirall": func() error { return ro.MkdirAll("/a/c/d", 0755) },
+		"remove":   func() error { return ro.Remove("/a/f") },
+		"rename":   func() error { return ro.Rename("/a/f", "/a/g") },
+		"chmod":    func() error { return ro.Chmod("/a/f", 0600) },
+		"chtimes":  func() error { return ro.Chtimes("/a/f", time.Now(), time.Now()) },
+		"symlink":  func() error { return ro.Symlink("f", "/a/s") },
+		"link":     func() error { return ro.Link("/a/f", "/a/h") },
+	}
+	for name, fn := range writes {
+		err := fn()
+		var perr *fs.PathError
+		if !errors.As(err, &perr) || !errors.Is(err, fs.ErrPermission) || !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%s: got %T %v, want an *fs.PathError with fs.ErrPermission", name, err, err)
+		}
+	}
+	if got := memRead(ro, "/a/link"); got != "hello" {
+		t.Errorf("read: got %q", got)
+	}
+	if entries, err := ro.ReadDir("/a"); err != nil || len(entries) != 4 {
+		t.Errorf("ReadDir: got %d entries, %v", len(entries), err)
+	}
+	if _, err := ro.Stat("/a/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat missing: got %v", err)
+	}
+
+	// a script sees the same errors
+	s := New()
+	s.FS = ro
+	s.Exec("cd /a", "mkdir c")
+	if !errors.Is(s.Error(), fs.ErrPermission) {
+		t.Errorf("mkdir: got %v", s.Error())
+	}
+}
@@ -0,0 +1,447 @@
+package gsh
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Wget downloads a URL to a file.
+//
+// The body is written to a temporary ".part" file next to the
+// output and renamed into place only after the transfer completes,
+// so a failed download never leaves a truncated file behind.
+// Responses outside of 2xx are errors.  Network errors, 5xx and 429
+// responses are retried with exponential backoff.  With -sha256 or
+// -sha512 the download is verified before being moved into place.
+//
+// Requests can carry headers (-H), a body (-data, -data-file) and
+// credentials.  WGET_TOKEN in the session environment is sent as a
+// bearer token, otherwise WGET_USER and WGET_PASSWORD are sent as
+// basic auth.  With -netrc, credentials are looked up in $NETRC
+// or $HOME/.netrc.  Explicit -H headers override all of these.
+func Wget(s *Session, cli []string) error {
+	name, fargs := cli[0], cli[1:]
+	w := wget{name: name, client: s.httpClient(), fs: s.FS}
+	f := flag.NewFlagSet(name, flag.ContinueOnError)
+	f.StringVar(&w.method, "method", "GET", "HTTP method")
+	f.StringVar(&w.output, "O", "", "Output file, '-' for stdout")
+	flagNoClobber := f.Bool("nc", false, "No Clobber, do not download if file already exists")
+	f.BoolVar(&w.resume, "c", false, "Continue a partial download")
+	f.BoolVar(&w.newer, "N", false, "Only download if remote file is newer than local file")
+	f.StringVar(&w.etag, "etag", "", "Only download if remote entity tag does not match")
+	flagTries := f.Int("tries", 3, "Number of attempts")
+	flagWait := f.Duration("waitretry", time.Second, "Wait between attempts, doubled after each")
+	flagSha256 := f.String("sha256", "", "Verify download has this SHA256 checksum")
+	flagSha512 := f.String("sha512", "", "Verify download has this SHA512 checksum")
+	f.Var(&w.headers, "H", "Extra header 'Key: Value', may be repeated")
+	flagData := f.String("data", "", "Send as request body, implies POST")
+	flagDataFile := f.String("data-file", "", "Send contents of file as request body, implies POST")
+	flagNetrc := f.Bool("netrc", false, "Read credentials from .netrc")
+	f.BoolVar(&w.showHeaders, "S", false, "Print response headers to stderr")
+	f.StringVar(&w.writeOut, "w", "", "Print format to stdout when done, e.g. '%{http_code}'")
+	err := f.Parse(fargs)
+	if err != nil {
+		return err
+	}
+	methodSet := false
+	f.Visit(func(fl *flag.Flag) { methodSet = methodSet || fl.Name == "method" })
+	args := f.Args()
+	if len(args) != 1 {
+		return fmt.Errorf("%s requires exactly one arg, got %d", name, len(args))
+	}
+	// this is the url
+	w.url = args[0]
+	if *flagSha256 != "" {
+		w.sums = append(w.sums, digest{"sha256", sha256.New, *flagSha256})
+	}
+	if *flagSha512 != "" {
+		w.sums = append(w.sums, digest{"sha512", sha512.New, *flagSha512})
+	}
+	switch {
+	case *flagData != "" && *flagDataFile != "":
+		return fmt.Errorf("%s: only one of -data and -data-file allowed", name)
+	case *flagData != "":
+		w.body = []byte(*flagData)
+	case *flagDataFile == "-":
+		if s.Stdin == nil {
+			return fmt.Errorf("%s: no input for -data-file", name)
+		}
+		w.body, err = io.ReadAll(s.Stdin)
+	case *flagDataFile != "":
+		w.body, err = readFile(s.FS, s.Abs(*flagDataFile))
+	}
+	if err != nil {
+		return fmt.Errorf("%s: unable to read data: %s", name, err)
+	}
+	if w.body != nil && !methodSet {
+		w.method = "POST"
+	}
+
+	switch {
+	case s.GetEnv("WGET_TOKEN") != "":
+		w.auth = "Bearer " + s.GetEnv("WGET_TOKEN")
+	case s.GetEnv("WGET_USER") != "":
+		w.auth = basicAuth(s.GetEnv("WGET_USER"), s.GetEnv("WGET_PASSWORD"))
+	case *flagNetrc:
+		netrc := s.GetEnv("NETRC")
+		if netrc == "" {
+			netrc = filepath.Join(s.GetEnv("HOME"), ".netrc")
+		}
+		if u, err := url.Parse(w.url); err == nil {
+			if login, password, ok := netrcLookup(s.FS, netrc, u.Hostname()); ok {
+				w.auth = basicAuth(login, password)
+			}
+		}
+	}
+
+	if err := s.policy.checkURL(w.method, w.url); err != nil {
+		return err
+	}
+
+	if w.output == "" {
+		w.output = outputName(w.url)
+	}
+	if w.output == "-" {
+		w.stdout = s.Stdout
+	} else {
+		w.output = s.Abs(w.output)
+		w.partial = w.output + ".part"
+	}
+	w.stderr = s.Stderr
+
+	if *flagNoClobber && w.stdout == nil && fileExists(s.FS, w.output) {
+		return nil
+	}
+
+	wait := *flagWait
+	for try := 1; ; try++ {
+		var retry bool
+		retry, err = w.fetch()
+		if err == nil || !retry || try >= *flagTries {
+			break
+		}
+		log.Printf("%s: attempt %d failed, retrying in %s: %s", name, try, wait, err)
+		time.Sleep(wait)
+		wait *= 2
+	}
+	if w.writeOut != "" && s.Stdout != nil {
+		io.WriteString(s.Stdout, w.formatWriteOut())
+	}
+	if err != nil {
+		if w.partial != "" && !w.resume {
+			s.FS.Remove(w.partial)
+		}
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	return nil
+}
+
+// wget is the state of a single download
+type wget struct {
+	name    string
+	client  *http.Client
+	fs      FS
+	method  string
+	url     string
+	output  string
+	partial string
+	stdout  io.Writer
+	resume  bool
+	newer   bool
+	etag    string
+	sums    []digest
+	headers headerFlags
+	body    []byte
+	auth    string
+
+	showHeaders bool
+	stderr      io.Writer
+	writeOut    string
+
+	// filled in by the last attempt, for -w
+	start       time.Time
+	code        int
+	contentType string
+	size        int64
+}
+
+// headerFlags collects repeated -H flags
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(val string) error {
+	if strings.IndexByte(val, ':') < 1 {
+		return fmt.Errorf("header %q is not 'Key: Value'", val)
+	}
+	*h = append(*h, val)
+	return nil
+}
+
+// basicAuth makes an Authorization header value
+func basicAuth(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+// formatWriteOut expands %{variable} and backslash escapes in the -w
+// format, using the results of the last attempt.
+func (w *wget) formatWriteOut() string {
+	vars := map[string]string{
+		"http_code":     strconv.Itoa(w.code),
+		"response_code": strconv.Itoa(w.code),
+		"size_download": strconv.FormatInt(w.size, 10),
+		"content_type":  w.contentType,
+		"url_effective": w.url,
+		"time_total":    fmt.Sprintf("%.6f", time.Since(w.start).Seconds()),
+	}
+	out := strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\\`, `\`).Replace(w.writeOut)
+	var buf strings.Builder
+	for {
+		idx := strings.Index(out, "%{")
+		if idx == -1 {
+			break
+		}
+		end := strings.IndexByte(out[idx:], '}')
+		if end == -1 {
+			break
+		}
+		buf.WriteString(out[:idx])
+		buf.WriteString(vars[out[idx+2:idx+end]])
+		out = out[idx+end+1:]
+	}
+	buf.WriteString(out)
+	return buf.String()
+}
+
+// digest is an expected checksum of a download
+type digest struct {
+	name    string
+	newHash func() hash.Hash
+	want    string
+}
+
+// hashes returns a new hash for each expected checksum
+func (w *wget) hashes() []hash.Hash {
+	hashes := make([]hash.Hash, len(w.sums))
+	for i, d := range w.sums {
+		hashes[i] = d.newHash()
+	}
+	return hashes
+}
+
+// check compares the computed hashes against the expected checksums
+func (w *wget) check(hashes []hash.Hash) error {
+	for i, d := range w.sums {
+		got := hex.EncodeToString(hashes[i].Sum(nil))
+		if !strings.EqualFold(got, d.want) {
+			return fmt.Errorf("%s checksum mismatch for %s: got %s, want %s",
+				d.name, w.url, got, d.want)
+		}
+	}
+	return nil
+}
+
+// hashWriter writes to dst, if not nil, and to all the hashes
+func hashWriter(dst io.Writer, hashes []hash.Hash) io.Writer {
+	writers := make([]io.Writer, 0, len(hashes)+1)
+	if dst != nil {
+		writers = append(writers, dst)
+	}
+	for _, h := range hashes {
+		writers = append(writers, h)
+	}
+	return io.MultiWriter(writers...)
+}
+
+// statusError is returned when the server responds with a non-2xx status
+type statusError struct {
+	method string
+	url    string
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.method, e.url, e.status)
+}
+
+// temporary reports if the request is worth retrying
+func (e *statusError) temporary() bool {
+	return e.code >= 500 || e.code == http.StatusTooManyRequests
+}
+
+// fetch makes one attempt at the download.  It returns true if the
+// error is transient and the download should be tried again.
+func (w *wget) fetch() (bool, error) {
+	var body io.Reader
+	if w.body != nil {
+		body = bytes.NewReader(w.body)
+	}
+	req, err := http.NewRequest(w.method, w.url, body)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %s", err)
+	}
+	if w.auth != "" {
+		req.Header.Set("Authorization", w.auth)
+	}
+	for _, kv := range w.headers {
+		idx := strings.IndexByte(kv, ':')
+		req.Header.Set(strings.TrimSpace(kv[:idx]), strings.TrimSpace(kv[idx+1:]))
+	}
+
+	var offset int64
+	if w.resume && w.partial != "" {
+		if info, err := w.fs.Stat(w.partial); err == nil && info.Size() > 0 {
+			offset = info.Size()
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+	}
+	if w.etag != "" {
+		req.Header.Set("If-None-Match", w.etag)
+	}
+	if w.newer && w.stdout == nil {
+		if info, err := w.fs.Stat(w.output); err == nil {
+			req.Header.Set("If-Modified-Since", info.ModTime().UTC().Format(http.TimeFormat))
+		}
+	}
+
+	w.start = time.Now()
+	resp, err := w.client.Do(req)
+	if err != nil {
+		// a redirect to a host the policy does not allow
+		var perr *PolicyError
+		if errors.As(err, &perr) {
+			return false, perr
+		}
+		return true, fmt.Errorf("request failed: %s", err)
+	}
+	defer resp.Body.Close()
+	w.code = resp.StatusCode
+	w.contentType = resp.Header.Get("Content-Type")
+	w.size = 0
+	if w.showHeaders && w.stderr != nil {
+		fmt.Fprintf(w.stderr, "%s %s\n", resp.Proto, resp.Status)
+		resp.Header.Write(w.stderr)
+		io.WriteString(w.stderr, "\n")
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		log.Printf("%s: %s not modified", w.name, w.url)
+		return false, nil
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0 &&
+		resp.Header.Get("Content-Range") == fmt.Sprintf("bytes */%d", offset):
+		// partial file is already complete
+		return false, w.finish(resp)
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// not the file the partial is from, start over without it
+		w.fs.Remove(w.partial)
+		return true, fmt.Errorf("%s: range not satisfiable, %q does not match a partial file of %d bytes",
+			w.url, resp.Header.Get("Content-Range"), offset)
+	case resp.StatusCode == http.StatusPartialContent && offset > 0 &&
+		strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)):
+		flags = os.O_WRONLY | os.O_APPEND
+	case resp.StatusCode == http.StatusPartialContent:
+		// not the part asked for, start over without the partial file
+		if w.partial != "" {
+			w.fs.Remove(w.partial)
+		}
+		return true, fmt.Errorf("%s: partial content %q does not resume at %d",
+			w.url, resp.Header.Get("Content-Range"), offset)
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		// server ignored the Range request, start over
+	default:
+		e := &statusError{
+			method: w.method,
+			url:    w.url,
+			code:   resp.StatusCode,
+			status: resp.Status,
+		}
+		return e.temporary(), e
+	}
+
+	if w.stdout != nil {
+		// output can not be taken back, so verification
+		// can only report the problem after the fact
+		hashes := w.hashes()
+		w.size, err = io.Copy(hashWriter(w.stdout, hashes), resp.Body)
+		if err != nil {
+			// output already started, can not retry
+			return false, fmt.Errorf("request copy failed: %s", err)
+		}
+		return false, w.check(hashes)
+	}
+
+	out, err := w.fs.OpenFile(w.partial, flags, 0666)
+	if err != nil {
+		return false, fmt.Errorf("unable to create output file: %s", err)
+	}
+	w.size, err = io.Copy(out, resp.Body)
+	cerr := out.Close()
+	if err != nil {
+		return true, fmt.Errorf("request copy failed: %s", err)
+	}
+	if cerr != nil {
+		return false, fmt.Errorf("unable to write output file: %s", cerr)
+	}
+	return false, w.finish(resp)
+}
+
+// finish verifies a completed partial download and moves it into
+// place, setting the modification time from the server if available.
+func (w *wget) finish(resp *http.Response) error {
+	if len(w.sums) > 0 {
+		in, err := openFile(w.fs, w.partial)
+		if err != nil {
+			return err
+		}
+		hashes := w.hashes()
+		_, err = io.Copy(hashWriter(nil, hashes), in)
+		in.Close()
+		if err == nil {
+			err = w.check(hashes)
+		}
+		if err != nil {
+			// corrupt, do not resume from it
+			w.fs.Remove(w.partial)
+			return err
+		}
+	}
+	if lastmod, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		w.fs.Chtimes(w.partial, lastmod, lastmod)
+	}
+	return w.fs.Rename(w.partial, w.output)
+}
+
+// outputName picks a local file name for a URL, like wget does.
+func outputName(source string) string {
+	p := source
+	if u, err := url.Parse(source); err == nil {
+		p = u.Path
+	}
+	base := path.Base(p)
+	if base == "." || base == "/" {
+		return "index.html"
+	}
+	return base
+}
@@ -0,0 +1,197 @@
+package gsh
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func wgetServer(t *testing.T) *httptest.Server {
+	modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	flaky := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/hello.txt":
+			http.ServeContent(w, r, "hello.txt", modTime, strings.NewReader("hello world"))
+		case "/badrange":
+			// ignores the offset asked for
+			if r.Header.Get("Range") != "" {
+				w.Header().Set("Content-Range", "bytes 3-10/11")
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write([]byte("lo world"))
+				return
+			}
+			w.Write([]byte("hello world"))
+		case "/flaky":
+			mu.Lock()
+			flaky++
+			n := flaky
+			mu.Unlock()
+			if n < 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("hello world"))
+		case "/echo":
+			fmt.Fprintf(w, "%s auth=%q x=%q", r.Method, r.Header.Get("Authorization"), r.Header.Get("X-Foo"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestWget(t *testing.T) {
+	ts := wgetServer(t)
+	const sum = "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+	tests := []struct {
+		name    string
+		args    string
+		partial string // an earlier partial download
+		want    string // the file, or stdout for "-O -"
+		err     bool
+	}{
+		{"get", "/hello.txt", "", "hello world", false},
+		{"stdout", "-O - /hello.txt", "", "hello world", false},
+		{"not found", "/missing", "", "", true},
+		{"retry", "-waitretry 1ms /flaky", "", "hello world", false},
+		{"resume", "-c /hello.txt", "hello", "hello world", false},
+		{"resume complete", "-c /hello.txt", "hello world", "hello world", false},
+		{"resume too long", "-c -waitretry 1ms /hello.txt", "hello world and more", "hello world", false},
+		{"resume wrong range", "-c -waitretry 1ms /badrange", "hello", "hello world", false},
+		{"no resume", "/hello.txt", "junk", "hello world", false},
+		{"checksum", "-sha256 " + sum + " /hello.txt", "", "hello world", false},
+		{"bad checksum", "-sha256 0000 /hello.txt", "", "", true},
+		{"headers", "-O - -H 'X-Foo: bar baz' /echo", "", `GET auth="" x="bar baz"`, false},
+		{"method", "-O - -method PUT /echo", "", `PUT auth="" x=""`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			out := filepath.Join(dir, "out")
+			if tt.partial != "" {
+				os.WriteFile(out+".part", []byte(tt.partial), 0644)
+			}
+			words := strings.Fields(tt.args)
+			url := ts.URL + words[len(words)-1]
+			opts := strings.Join(words[:len(words)-1], " ")
+			if !strings.Contains(opts, "-O -") {
+				opts += " -O " + out
+			}
+			s := New()
+			var stdout strings.Builder
+			s.Stdout, s.Stderr = &stdout, &strings.Builder{}
+			err := s.Exec("wget " + opts + " " + url)
+			if (err != nil) != tt.err {
+				t.Fatalf("error %v", err)
+			}
+			got := stdout.String()
+			if !strings.Contains(opts, "-O -") {
+				b, _ := os.ReadFile(out)
+				got = string(b)
+				if _, err := os.Stat(out + ".part"); err == nil {
+					t.Error("partial file left behind")
+				}
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWgetNotModified(t *testing.T) {
+	ts := wgetServer(t)
+	out := filepath.Join(t.TempDir(), "out")
+	s := New()
+	if err := s.Exec("wget -O " + out + " " + ts.URL + "/hello.txt"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(out)
+	if err != nil || !info.ModTime().Equal(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("modification time not set: %v", err)
+	}
+	os.WriteFile(out, []byte("local"), 0644)
+	os.Chtimes(out, time.Now(), time.Now())
+	if err := s.Exec("wget -N -O " + out + " " + ts.URL + "/hello.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(out); string(b) != "local" {
+		t.Errorf("newer file replaced with %q", b)
+	}
+}
+
+func TestWgetRequest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := new(strings.Builder)
+		io.Copy(body, r.Body)
+		w.Header().Set("X-Reply", "yes")
+		fmt.Fprintf(w, "%s auth=%q x=%q body=%q", r.Method, r.Header.Get("Authorization"), r.Header.Get("X-Foo"), body.String())
+	}))
+	defer ts.Close()
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "netrc"), []byte("machine 127.0.0.1 login bob password pw\n"), 0600)
+	os.WriteFile(filepath.Join(dir, "body.json"), []byte(`{"a":1}`), 0644)
+
+	tests := []struct {
+		name   string
+		env    map[string]string
+		args   string
+		stdin  string
+		want   string
+		stderr string // in the stderr output
+		err    bool
+	}{
+		{"get", nil, "", "", `GET auth="" x="" body=""`, "", false},
+		{"header", nil, "-H 'X-Foo: bar baz'", "", `GET auth="" x="bar baz" body=""`, "", false},
+		{"data", nil, "-data a=1", "", `POST auth="" x="" body="a=1"`, "", false},
+		{"data put", nil, "-method PUT -data a=1", "", `PUT auth="" x="" body="a=1"`, "", false},
+		{"data file", nil, "-data-file body.json", "", `POST auth="" x="" body="{\"a\":1}"`, "", false},
+		{"data stdin", nil, "-data-file -", "in", `POST auth="" x="" body="in"`, "", false},
+		{"data both", nil, "-data a -data-file body.json", "", "", "", true},
+		{"token", map[string]string{"WGET_TOKEN": "tok"}, "", "", `GET auth="Bearer tok" x="" body=""`, "", false},
+		{"basic", map[string]string{"WGET_USER": "bob", "WGET_PASSWORD": "pw"}, "", "", `GET auth="Basic Ym9iOnB3" x="" body=""`, "", false},
+		{"netrc", map[string]string{"NETRC": "netrc"}, "-netrc", "", `GET auth="Basic Ym9iOnB3" x="" body=""`, "", false},
+		{"netrc off", map[string]string{"NETRC": "netrc"}, "", "", `GET auth="" x="" body=""`, "", false},
+		{"header wins", map[string]string{"WGET_TOKEN": "tok"}, "-H 'Authorization: mine'", "", `GET auth="mine" x="" body=""`, "", false},
+		{"show headers", nil, "-S", "", `GET auth="" x="" body=""`, "X-Reply: yes", false},
+		{"write out", nil, `-w '\n%{http_code}'`, "", "GET auth=\"\" x=\"\" body=\"\"\n200", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New()
+			s.Exec("cd " + dir)
+			for k, v := range tt.env {
+				if k == "NETRC" {
+					v = filepath.Join(dir, v)
+				}
+				s.Env[k] = v
+			}
+			var stdout, stderr strings.Builder
+			s.Stdout, s.Stderr = &stdout, &stderr
+			s.Stdin = strings.NewReader(tt.stdin)
+			err := s.Exec("wget -O - " + tt.args + " " + ts.URL + "/")
+			if (err != nil) != tt.err {
+				t.Fatalf("error %v", err)
+			}
+			if tt.err {
+				return
+			}
+			if got := strings.TrimSuffix(stdout.String(), "\n"); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if !strings.Contains(stderr.String(), tt.stderr) {
+				t.Errorf("stderr %q does not have %q", stderr.String(), tt.stderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package gsh
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultIFS is used for word splitting if IFS is not set
+const defaultIFS = " \t\n"
+
+// field is one word of an expanded command.  pat is the word as a
+// glob pattern, with quoted characters escaped, and glob is set if
+// it has unquoted pattern characters.
+type field struct {
+	val  string
+	pat  string
+	glob bool
+}
+
+// fieldBuilder collects the fields produced by expanding a command
+type fieldBuilder struct {
+	fields []field
+	cur    strings.Builder
+	pat    strings.Builder
+	glob   bool
+	active bool // cur is a field, even if empty
+}
+
+// add adds quoted text to the current field
+func (b *fieldBuilder) add(str string) {
+	b.cur.WriteString(str)
+	for i := 0; i < len(str); i++ {
+		if strings.IndexByte(globChars, str[i]) != -1 {
+			b.pat.WriteByte('\\')
+		}
+		b.pat.WriteByte(str[i])
+	}
+	b.active = true
+}
+
+// addUnquoted adds unquoted text, which may be a glob pattern
+func (b *fieldBuilder) addUnquoted(str string) {
+	b.cur.WriteString(str)
+	b.pat.WriteString(str)
+	if strings.ContainsAny(str, "*?[") {
+		b.glob = true
+	}
+	b.active = true
+}
+
+func (b *fieldBuilder) finish() {
+	if b.active {
+		pat := b.pat.String()
+		b.fields = append(b.fields, field{val: b.cur.String(), pat: pat, glob: b.glob && hasGlobChars(pat)})
+	}
+	b.cur.Reset()
+	b.pat.Reset()
+	b.glob = false
+	b.active = false
+}
+
+// split adds the result of an unquoted expansion, splitting it
+// into fields on the characters in ifs.
+func (b *fieldBuilder) split(val string, ifs string) {
+	if val == "" {
+		return
+	}
+	if ifs == "" {
+		b.addUnquoted(val)
+		return
+	}
+	if strings.IndexByte(ifs, val[0]) != -1 {
+		b.finish()
+	}
+	pieces := strings.FieldsFunc(val, func(r rune) bool {
+		return strings.ContainsRune(ifs, r)
+	})
+	for i, piece := range pieces {
+		if i > 0 {
+			b.finish()
+		}
+		b.addUnquoted(piece)
+	}
+	if strings.IndexByte(ifs, val[len(val)-1]) != -1 {
+		b.finish()
+	}
+}
+
+// expandWords splits a command into words and expands them.
+//
+// Quoting follows the shell: single quotes are literal, double
+// quotes allow expansion but not word splitting, and a backslash
+// quotes the next character.  Braces are expanded first, and the
+// results of unquoted expansions are split on IFS.  Expanded values
+// are never parsed again, so quotes or variables inside of them have
+// no effect.  Last, words with unquoted "*", "?" or "[" are replaced
+// by the matching file names.
+func (s *Session) expandWords(cmd string) ([]string, error) {
+	ifs, ok := s.lookupVar("IFS")
+	if !ok {
+		ifs = defaultIFS
+	}
+	if s.opts.braceexpand {
+		var err error
+		if cmd, err = expandBraces(cmd); err != nil {
+			return nil, err
+		}
+	}
+	fields, err := s.fields(cmd, ifs, true)
+	if err != nil {
+		return nil, err
+	}
+	words := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if !f.glob || s.opts.noglob {
+			words = append(words, f.val)
+			continue
+		}
+		matches, err := s.glob(f.pat)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case len(matches) > 0:
+			words = append(words, matches...)
+		case s.opts.failglob:
+			return nil, fmt.Errorf("no match: %s", f.val)
+		case !s.opts.nullglob:
+			words = append(words, f.val)
+		}
+	}
+	return words, nil
+}
+
+// expandString expands a single word with quoting, but without
+// splitting.  It is used for the words inside of ${VAR:-word}.
+func (s *Session) expandString(word string) (string, error) {
+	fields, err := s.fields(word, "", false)
+	if err != nil {
+		return "", err
+	}
+	var buf strings.Builder
+	for _, f := range fields {
+		buf.WriteString(f.val)
+	}
+	return buf.String(), nil
+}
+
+// fields does the work of expandWords.  If blanks is false then
+// spaces do not separate words.
+func (s *Session) fields(cmd string, ifs string, blanks bool) ([]field, error) {
+	b := &fieldBuilder{}
+	for i := 0; i < len(cmd); i++ {
+		switch c := cmd[i]; c {
+		case ' ', '\t', '\n', '\r':
+			if !blanks {
+				b.addUnquoted(cmd[i : i+1])
+				continue
+			}
+			b.finish()
+		case '\\':
+			if i+1 < len(cmd) {
+				i++
+				b.add(cmd[i : i+1])
+			} else {
+				b.add("\\")
+			}
+		case '\'':
+			end := strings.IndexByte(cmd[i+1:], '\'')
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated ' quote")
+			}
+			b.add(cmd[i+1 : i+1+end])
+			i += end + 1
+		case '"':
+			end, err := s.expandDouble(b, cmd, i+1)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+		case '$':
+			expr, end, err := scanParam(cmd, i)
+			if err != nil {
+				return nil, err
+			}
+			if end == i+1 {
+				b.add("$")
+				continue
+			}
+			if expr == "@" || expr == "*" {
+				// each arg is its own field, and is split
+				for _, arg := range s.positional() {
+					b.split(arg, ifs)
+					b.finish()
+				}
+				i = end - 1
+				continue
+			}
+			val, err := s.expandParam(expr)
+			if err != nil {
+				return nil, err
+			}
+			b.split(val, ifs)
+			i = end - 1
+		default:
+			b.addUnquoted(cmd[i : i+1])
+		}
+	}
+	b.finish()
+	return b.fields, nil
+}
+
+// expandDouble expands the inside of a double quoted string starting
+// at cmd[start].  It returns the index of the closing quote.
+func (s *Session) expandDouble(b *fieldBuilder, cmd string, start int) (int, error) {
+	// "$@" with no args is no field at all
+	if strings.HasPrefix(cmd[start:], `$@"`) && len(s.positional()) == 0 {
+		return start + 2, nil
+	}
+	// otherwise even "" is a field
+	b.add("")
+	for i := start; i < len(cmd); i++ {
+		switch c := cmd[i]; c {
+		case '"':
+			return i, nil
+		case '\\':
+			if i+1 < len(cmd) && strings.IndexByte("$`\"\\\n", cmd[i+1]) != -1 {
+				i++
+				if cmd[i] != '\n' {
+					b.add(cmd[i : i+1])
+				}
+			} else {
+				b.add("\\")
+			}
+		case '$':
+			expr, end, err := scanParam(cmd, i)
+			if err != nil {
+				return 0, err
+			}
+			if end == i+1 {
+				b.add("$")
+				continue
+			}
+			if expr == "@" {
+				// "$@" is each arg as its own field
+				for j, arg := range s.positional() {
+					if j > 0 {
+						b.finish()
+						b.add("")
+					}
+					b.add(arg)
+				}
+				i = end - 1
+				continue
+			}
+			val, err := s.expandParam(expr)
+			if err != nil {
+				return 0, err
+			}
+			b.add(val)
+			i = end - 1
+		default:
+			b.add(cmd[i : i+1])
+		}
+	}
+	return 0, fmt.Errorf("unterminated \" quote")
+}
+
+// hereDocText expands the body of a here-document or here-string
+func (s *Session) hereDocText(doc *hereDoc) (string, error) {
+	switch {
+	case doc.word:
+		word, err := s.expandString(doc.text)
+		return word + "\n", err
+	case !doc.expand:
+		return doc.text, nil
+	}
+
+	// like inside double quotes, but a " is not special
+	var buf strings.Builder
+	text := doc.text
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; c {
+		case '\\':
+			if i+1 < len(text) && strings.IndexByte("$`\\\n", text[i+1]) != -1 {
+				i++
+				if text[i] != '\n' {
+					buf.WriteByte(text[i])
+				}
+			} else {
+				buf.WriteByte(c)
+			}
+		case '$':
+			expr, end, err := scanParam(text, i)
+			if err != nil {
+				return "", err
+			}
+			if end == i+1 {
+				buf.WriteByte(c)
+				continue
+			}
+			val, err := s.expandParam(expr)
+			if err != nil {
+				return "", err
+			}
+			buf.WriteString(val)
+			i = end - 1
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,78 @@
+package gsh
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQuoting(t *testing.T) {
+	tests := []struct {
+		script string
+		want   string
+		err    bool
+	}{
+		{"x=1\necho '$x' \"$x\" \\$x", "$x 1 $x", false},
+		{"x='a  b'\necho \"$x\"", "a  b", false},
+		{"x='a  b'\necho $x", "a b", false},
+		{"x='a  b'\nn() { echo $#; }\nn $x \"$x\" '' \"\"", "5", false},
+		{"x=a:b\nIFS=:\nn() { echo $#; }\nn $x", "2", false},
+		{"x='$y'\ny=1\necho $x", "$y", false},
+		{"x=\"'a'\"\necho $x", "'a'", false},
+		{"echo \"a\\\"b\" 'a\\b'", "a\"b a\\b", false},
+		{"echo \"it's\" 'say \"hi\"'", "it's say \"hi\"", false},
+		{"echo a'b'\"c\"", "abc", false},
+		{"echo 'a", "", true},
+		{"echo \"a", "", true},
+	}
+	for _, tt := range tests {
+		s := New()
+		var out strings.Builder
+		s.Stdout = &out
+		err := s.Exec(tt.script)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: error %v", tt.script, err)
+			continue
+		}
+		if out.String() != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.script, out.String(), tt.want)
+		}
+	}
+}
+
+func TestHereDoc(t *testing.T) {
+	tests := []struct {
+		script string
+		want   string
+		err    bool
+	}{
+		{"x=v\ncat <<EOF\n$x ${x}\nEOF", "v v\n", false},
+		{"x=v\ncat <<'EOF'\n$x\nEOF", "$x\n", false},
+		{"x=v\ncat <<\"EOF\"\n$x\nEOF", "$x\n", false},
+		{"x=v\ncat <<\\EOF\n$x\nEOF", "$x\n", false},
+		{"cat <<EOF\n\\$x \"q\" 'q'\nEOF", "$x \"q\" 'q'\n", false},
+		{"cat <<-EOF\n\ta\n\t\tb\n\tEOF", "a\nb\n", false},
+		{"cat <<EOF\n\ta\n\tEOF\nEOF", "\ta\n\tEOF\n", false},
+		{"cat <<EOF\nEOF", "", false},
+		{"cat <<A <<B\na\nA\nb\nB", "b\n", false},
+		{"cat <<EOF\na\nEOF\nprintf b", "a\nb", false},
+		{"x=v\ncat <<< $x", "v\n", false},
+		{"x='a  b'\ncat <<< $x", "a  b\n", false},
+		{"cat <<< 'a b'", "a b\n", false},
+		{"cat <<EOF\na", "", true},
+		{"cat <<EOF extra\na\nEOF", "", true},
+		{"cat <<", "", true},
+	}
+	for _, tt := range tests {
+		s := New()
+		var out strings.Builder
+		s.Stdout = &out
+		err := s.Exec(tt.script)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: error %v", tt.script, err)
+			continue
+		}
+		if out.String() != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.script, out.String(), tt.want)
+		}
+	}
+}